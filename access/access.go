@@ -0,0 +1,98 @@
+package access
+
+import (
+	"errors"
+
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var db *gorm.DB
+
+func InitDb(database *gorm.DB) {
+	db = database
+}
+
+// Level is what a user may do with a document. Higher levels include the
+// lower ones.
+type Level int
+
+const (
+	None Level = iota
+	Read
+	Write
+	Owner
+)
+
+// DocumentLevel works out a user's access to a document: authors own their
+// documents, documents in a workspace are readable by every member and
+// writable by everyone but guests, and anyone can be let in to a single
+// document as a viewer or editor. The highest of those wins.
+func DocumentLevel(userID uuid.UUID, doc *models.Document) (Level, error) {
+	if doc.AuthorID == userID {
+		return Owner, nil
+	}
+
+	level := None
+	if doc.WorkspaceID != nil {
+		role, err := WorkspaceRole(*doc.WorkspaceID, userID)
+		if err != nil {
+			return None, err
+		}
+		switch role {
+		case models.WorkspaceRoleAdmin, models.WorkspaceRoleMember:
+			level = Write
+		case models.WorkspaceRoleGuest:
+			level = Read
+		}
+	}
+	if level == Write {
+		return level, nil
+	}
+
+	var permission models.DocumentPermission
+	err := db.First(&permission, "document_id = ? AND user_id = ?", doc.ID, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return level, nil
+	}
+	if err != nil {
+		return None, err
+	}
+	if granted := RoleLevel(permission.Role); granted > level {
+		level = granted
+	}
+	return level, nil
+}
+
+// RoleLevel is the access a document role gives.
+func RoleLevel(role string) Level {
+	switch role {
+	case models.DocumentRoleEditor:
+		return Write
+	case models.DocumentRoleViewer:
+		return Read
+	}
+	return None
+}
+
+// WorkspaceRole returns the user's role in the workspace, or "" if they
+// aren't a member.
+func WorkspaceRole(workspaceID uuid.UUID, userID uuid.UUID) (string, error) {
+	var member models.WorkspaceMember
+	err := db.First(&member, "workspace_id = ? AND user_id = ?", workspaceID, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return member.Role, nil
+}
+
+// WorkspaceIDs lists the workspaces the user is a member of.
+func WorkspaceIDs(userID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := db.Model(&models.WorkspaceMember{}).Where("user_id = ?", userID).Pluck("workspace_id", &ids).Error
+	return ids, err
+}
@@ -0,0 +1,392 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dipankarupd/text-editor/access"
+	"github.com/dipankarupd/text-editor/audit"
+	"github.com/dipankarupd/text-editor/middlewares"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/notify"
+	"github.com/dipankarupd/text-editor/webhooks"
+	"github.com/dipankarupd/text-editor/ws"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var errRequestAnswered = errors.New("access request already answered")
+
+// how long after a denial the same user can ask for the document again
+const accessRequestCooldown = 24 * time.Hour
+
+// documentUser is someone the owner is deciding about, so the email is shown
+// too.
+type documentUser struct {
+	ID    uuid.UUID `json:"id"`
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+}
+
+type accessRequestResponse struct {
+	models.AccessRequest
+	Requester *documentUser `json:"requester"`
+}
+
+type documentPermissionResponse struct {
+	models.DocumentPermission
+	User *documentUser `json:"user"`
+}
+
+// documentUsers looks up the users by id.
+func documentUsers(ids []uuid.UUID) (map[uuid.UUID]*documentUser, error) {
+	users := make(map[uuid.UUID]*documentUser, len(ids))
+	if len(ids) == 0 {
+		return users, nil
+	}
+	var rows []models.User
+	if err := db.Select("id", "name", "email").Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, u := range rows {
+		users[u.ID] = &documentUser{ID: u.ID, Name: u.Name, Email: u.Email}
+	}
+	return users, nil
+}
+
+// CreateAccessRequest asks the owner of a document for access to it.
+func CreateAccessRequest() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Role    string `json:"role" validate:"required,oneof=viewer editor"`
+			Message string `json:"message" validate:"max=1000"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		// anyone may ask, so only the document's existence is checked
+		doc, ok := loadDocument(ctx, userID, access.None)
+		if !ok {
+			return
+		}
+		level, err := access.DocumentLevel(userID, doc)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if level >= access.RoleLevel(body.Role) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "You already have this access"})
+			return
+		}
+
+		var pending int64
+		if err := db.Model(&models.AccessRequest{}).
+			Where("document_id = ? AND requester_id = ? AND status = ?", doc.ID, userID, models.AccessRequestPending).
+			Count(&pending).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if pending > 0 {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "You already asked for access to this document"})
+			return
+		}
+
+		// after a denial the owner isn't asked again for a while
+		var denied models.AccessRequest
+		err = db.Where("document_id = ? AND requester_id = ? AND status = ? AND decided_at > ?",
+			doc.ID, userID, models.AccessRequestDenied, time.Now().Add(-accessRequestCooldown)).
+			Order("decided_at DESC").Limit(1).Find(&denied).Error
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if denied.DecidedAt != nil {
+			middlewares.RetryAfter(ctx, time.Until(denied.DecidedAt.Add(accessRequestCooldown)))
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "Your last request was denied, you can ask again later"})
+			return
+		}
+
+		request := models.AccessRequest{
+			ID:          uuid.New(),
+			DocumentID:  doc.ID,
+			RequesterID: userID,
+			Role:        body.Role,
+			Message:     body.Message,
+			Status:      models.AccessRequestPending,
+		}
+		if err := db.Create(&request).Error; err != nil {
+			// a request sent at the same time got past the count
+			if isDuplicateKey(err) {
+				ctx.JSON(http.StatusConflict, gin.H{"error": "You already asked for access to this document"})
+				return
+			}
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create the request"})
+			return
+		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.DocumentAccessRequested,
+			TargetType: audit.TargetDocument,
+			TargetID:   audit.ID(doc.ID),
+			Metadata:   map[string]interface{}{"request_id": request.ID, "role": request.Role},
+		})
+		notify.Send(ctx, notify.Event{
+			Type:       notify.AccessRequested,
+			UserID:     doc.AuthorID,
+			DocumentID: &doc.ID,
+			Data: map[string]interface{}{
+				"request_id": request.ID,
+				"role":       request.Role,
+				"title":      doc.Title,
+				"message":    request.Message,
+			},
+		})
+
+		ctx.JSON(http.StatusCreated, request)
+	}
+}
+
+// GetAccessRequests lists the requests for a document, for its owner.
+// ?status= is pending by default, or approved, denied or all.
+func GetAccessRequests() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		doc, ok := loadDocument(ctx, userID, access.Owner)
+		if !ok {
+			return
+		}
+
+		query := db.Where("document_id = ?", doc.ID)
+		switch status := ctx.DefaultQuery("status", models.AccessRequestPending); status {
+		case "all":
+		case models.AccessRequestPending, models.AccessRequestApproved, models.AccessRequestDenied:
+			query = query.Where("status = ?", status)
+		default:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+			return
+		}
+		var requests []models.AccessRequest
+		if err := query.Order("created_at DESC").Find(&requests).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the requests"})
+			return
+		}
+
+		ids := make([]uuid.UUID, len(requests))
+		for i, r := range requests {
+			ids[i] = r.RequesterID
+		}
+		users, err := documentUsers(ids)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the requesters"})
+			return
+		}
+		response := make([]accessRequestResponse, len(requests))
+		for i, r := range requests {
+			response[i] = accessRequestResponse{AccessRequest: r, Requester: users[r.RequesterID]}
+		}
+		ctx.JSON(http.StatusOK, response)
+	}
+}
+
+// ApproveAccessRequest lets the requester in with the role they asked for,
+// or the one in the body.
+func ApproveAccessRequest() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Role string `json:"role" validate:"omitempty,oneof=viewer editor"`
+		}
+		if err := ctx.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+		answerAccessRequest(ctx, models.AccessRequestApproved, body.Role)
+	}
+}
+
+func DenyAccessRequest() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		answerAccessRequest(ctx, models.AccessRequestDenied, "")
+	}
+}
+
+// answerAccessRequest settles a pending request and tells the requester.
+// role overrides the role asked for when approving.
+func answerAccessRequest(ctx *gin.Context, status, role string) {
+	requestID, err := uuid.Parse(ctx.Param("requestId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		return
+	}
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		return
+	}
+	doc, ok := loadDocument(ctx, userID, access.Owner)
+	if !ok {
+		return
+	}
+
+	var request models.AccessRequest
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&request, "id = ? AND document_id = ?", requestID, doc.ID).Error; err != nil {
+			return err
+		}
+		if role != "" {
+			request.Role = role
+		}
+		now := time.Now()
+		res := tx.Model(&models.AccessRequest{}).
+			Where("id = ? AND status = ?", request.ID, models.AccessRequestPending).
+			Updates(map[string]interface{}{
+				"status":     status,
+				"role":       request.Role,
+				"decided_by": userID,
+				"decided_at": now,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errRequestAnswered
+		}
+		request.Status, request.DecidedBy, request.DecidedAt = status, &userID, &now
+		if status != models.AccessRequestApproved {
+			return nil
+		}
+		return tx.Exec(`
+			INSERT INTO document_permissions (document_id, user_id, role, granted_by, created_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (document_id, user_id) DO UPDATE SET
+				role = EXCLUDED.role,
+				granted_by = EXCLUDED.granted_by
+		`, doc.ID, request.RequesterID, request.Role, userID, now).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Request not found"})
+		return
+	}
+	if errors.Is(err, errRequestAnswered) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "This request was already answered"})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to answer the request"})
+		return
+	}
+
+	action, notification := audit.DocumentAccessDenied, notify.AccessDenied
+	if status == models.AccessRequestApproved {
+		action, notification = audit.DocumentAccessGranted, notify.AccessApproved
+	}
+	audit.Record(ctx, audit.Event{
+		Action:     action,
+		TargetType: audit.TargetDocument,
+		TargetID:   audit.ID(doc.ID),
+		Metadata:   map[string]interface{}{"request_id": request.ID, "user_id": request.RequesterID, "role": request.Role},
+	})
+	if status == models.AccessRequestApproved {
+		webhooks.Dispatch(webhooks.DocumentShared, doc, map[string]interface{}{"user_id": request.RequesterID, "role": request.Role})
+	}
+	notify.Send(ctx, notify.Event{
+		Type:       notification,
+		UserID:     request.RequesterID,
+		DocumentID: &doc.ID,
+		Data:       map[string]interface{}{"request_id": request.ID, "role": request.Role, "title": doc.Title},
+	})
+
+	ctx.JSON(http.StatusOK, request)
+}
+
+// GetDocumentPermissions lists who was let in to the document, besides its
+// workspace.
+func GetDocumentPermissions() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		doc, ok := loadDocument(ctx, userID, access.Owner)
+		if !ok {
+			return
+		}
+
+		var permissions []models.DocumentPermission
+		if err := db.Where("document_id = ?", doc.ID).Order("created_at").Find(&permissions).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the permissions"})
+			return
+		}
+		ids := make([]uuid.UUID, len(permissions))
+		for i, p := range permissions {
+			ids[i] = p.UserID
+		}
+		users, err := documentUsers(ids)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the users"})
+			return
+		}
+		response := make([]documentPermissionResponse, len(permissions))
+		for i, p := range permissions {
+			response[i] = documentPermissionResponse{DocumentPermission: p, User: users[p.UserID]}
+		}
+		ctx.JSON(http.StatusOK, response)
+	}
+}
+
+// RevokeDocumentPermission takes back access given to one user. What they
+// get from the workspace stays.
+func RevokeDocumentPermission() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		memberID, err := uuid.Parse(ctx.Param("userId"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		doc, ok := loadDocument(ctx, userID, access.Owner)
+		if !ok {
+			return
+		}
+
+		res := db.Where("document_id = ? AND user_id = ?", doc.ID, memberID).Delete(&models.DocumentPermission{})
+		if res.Error != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke access"})
+			return
+		}
+		if res.RowsAffected == 0 {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Permission not found"})
+			return
+		}
+		// an open editor would otherwise keep its access until it reconnects
+		ws.RecheckAccess(memberID)
+		audit.Record(ctx, audit.Event{
+			Action:     audit.DocumentAccessRevoked,
+			TargetType: audit.TargetDocument,
+			TargetID:   audit.ID(doc.ID),
+			Metadata:   map[string]interface{}{"user_id": memberID},
+		})
+		ctx.JSON(http.StatusOK, gin.H{"success": "ok"})
+	}
+}
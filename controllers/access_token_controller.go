@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dipankarupd/text-editor/audit"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/pat"
+	"github.com/dipankarupd/text-editor/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+)
+
+const defaultAccessTokenDays = 90
+
+// GetAccessTokens lists the user's personal access tokens. The tokens
+// themselves were only shown once, when they were created.
+func GetAccessTokens() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		var tokens []models.PersonalAccessToken
+		if err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the tokens"})
+			return
+		}
+		ctx.JSON(http.StatusOK, tokens)
+	}
+}
+
+// CreateAccessToken makes a new personal access token and returns it in the
+// clear, the only time it can be seen.
+func CreateAccessToken() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			Name          string   `json:"name" validate:"required,max=100"`
+			Scopes        []string `json:"scopes" validate:"required,min=1"`
+			ExpiresInDays *int     `json:"expires_in_days" validate:"omitempty,min=0,max=365"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		scopes := []string{}
+		seen := map[string]bool{}
+		for _, scope := range body.Scopes {
+			if !pat.ValidScope(scope) {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope " + scope, "scopes": pat.Scopes})
+				return
+			}
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+
+		// 0 days means the token never expires
+		days := defaultAccessTokenDays
+		if body.ExpiresInDays != nil {
+			days = *body.ExpiresInDays
+		}
+		var expiresAt *time.Time
+		if days > 0 {
+			t := time.Now().AddDate(0, 0, days)
+			expiresAt = &t
+		}
+
+		raw := pat.Generate()
+		token := models.PersonalAccessToken{
+			ID:        uuid.New(),
+			UserID:    userID,
+			Name:      body.Name,
+			TokenHash: utils.HashToken(raw),
+			Prefix:    raw[:len(pat.Prefix)+6],
+			Scopes:    scopes,
+			ExpiresAt: expiresAt,
+		}
+		if err := db.Create(&token).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create the token"})
+			return
+		}
+
+		audit.Record(ctx, audit.Event{
+			Action:     audit.AccessTokenCreated,
+			TargetType: audit.TargetToken,
+			TargetID:   audit.ID(token.ID),
+			Metadata:   map[string]interface{}{"name": token.Name, "scopes": token.Scopes},
+		})
+
+		ctx.JSON(http.StatusCreated, gin.H{
+			"token":        raw,
+			"access_token": token,
+		})
+	}
+}
+
+// RevokeAccessToken deletes a personal access token; it stops working right
+// away.
+func RevokeAccessToken() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		tokenID, err := uuid.Parse(ctx.Param("id"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+			return
+		}
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		res := db.Where("id = ? AND user_id = ?", tokenID, userID).Delete(&models.PersonalAccessToken{})
+		if res.Error != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke the token"})
+			return
+		}
+		if res.RowsAffected == 0 {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+			return
+		}
+		audit.Record(ctx, audit.Event{Action: audit.AccessTokenRevoked, TargetType: audit.TargetToken, TargetID: audit.ID(tokenID)})
+		ctx.JSON(http.StatusOK, gin.H{"success": "ok"})
+	}
+}
@@ -0,0 +1,308 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dipankarupd/text-editor/audit"
+	"github.com/dipankarupd/text-editor/mailer"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+// issueUserToken creates a single use token for the user and returns it in
+// the clear; only its hash is kept.
+func issueUserToken(tx *gorm.DB, userID uuid.UUID, purpose string, ttl time.Duration, data ...string) (string, error) {
+	raw := utils.RandomToken(32)
+	token := models.UserToken{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: utils.HashToken(raw),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if len(data) > 0 {
+		token.Data = &data[0]
+	}
+	if err := tx.Create(&token).Error; err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// consumeUserToken marks the token used and returns it. The conditional
+// update makes sure two requests racing with the same token can't both win.
+func consumeUserToken(tx *gorm.DB, raw, purpose string) (*models.UserToken, error) {
+	var token models.UserToken
+	err := tx.Where("token_hash = ? AND purpose = ?", utils.HashToken(raw), purpose).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errInvalidUserToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return nil, errInvalidUserToken
+	}
+
+	res := tx.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, errInvalidUserToken
+	}
+	return &token, nil
+}
+
+// expireUserTokens retires the user's other outstanding tokens for a purpose.
+func expireUserTokens(tx *gorm.DB, userID uuid.UUID, purpose string) error {
+	return tx.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
+
+// appLink builds a link into the frontend, APP_URL.
+func appLink(path, token string) string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	return fmt.Sprintf("%s%s?token=%s", strings.TrimSuffix(base, "/"), path, token)
+}
+
+func sendVerificationEmail(user models.User) error {
+	raw, err := issueUserToken(db, user.ID, models.TokenVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	mailer.SendAsync(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening this link:\n\n%s\n\nThe link is valid for 48 hours.\n",
+			user.Name, appLink("/verify-email", raw)),
+	})
+	return nil
+}
+
+// VerifyEmail confirms the address with the token from the verification email.
+func VerifyEmail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Token string `json:"token" validate:"required"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			token, err := consumeUserToken(tx, body.Token, models.TokenVerifyEmail)
+			if err != nil {
+				return err
+			}
+			return tx.Model(&models.User{}).
+				Where("id = ? AND email_verified_at IS NULL", token.UserID).
+				Update("email_verified_at", time.Now()).Error
+		})
+		if errors.Is(err, errInvalidUserToken) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "This link is invalid or has expired"})
+			return
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{"success": "email verified"})
+	}
+}
+
+// ResendVerificationEmail sends a new verification link to the signed in user.
+func ResendVerificationEmail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
+			return
+		}
+		if user.EmailVerifiedAt != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified"})
+			return
+		}
+
+		if err := expireUserTokens(db, user.ID, models.TokenVerifyEmail); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if err := sendVerificationEmail(user); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{"success": "verification email sent"})
+	}
+}
+
+// ForgotPassword emails a reset link. The response is the same whether or
+// not the email has an account, so it can't be used to find users.
+func ForgotPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		// everything else happens after the response, so how long it takes
+		// doesn't tell whether the account exists
+		go sendPasswordReset(body.Email)
+		ctx.JSON(http.StatusOK, gin.H{"success": "If an account exists for this email, a reset link has been sent"})
+	}
+}
+
+// sendPasswordReset emails a reset link if the address has an account. Only
+// a few go to one address per hour, however many clients ask for them.
+func sendPasswordReset(email string) {
+	allowed, err := utils.AllowPasswordResetMail(context.Background(), email)
+	if err != nil {
+		log.Printf("Failed to check the reset mail limit: %v", err)
+		return
+	}
+	if !allowed {
+		return
+	}
+
+	var user models.User
+	err = db.Where("email = ?", email).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up the account for a password reset: %v", err)
+		return
+	}
+
+	// only the newest link works
+	var raw string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := expireUserTokens(tx, user.ID, models.TokenResetPassword); err != nil {
+			return err
+		}
+		raw, err = issueUserToken(tx, user.ID, models.TokenResetPassword, resetPasswordTTL)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to create a password reset token for %s: %v", user.ID, err)
+		return
+	}
+
+	mailer.SendAsync(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account. To choose a new one, open this link:\n\n%s\n\nThe link is valid for one hour. If it wasn't you, you can ignore this email.\n",
+			user.Name, appLink("/reset-password", raw)),
+	})
+}
+
+// ResetPassword sets a new password with the token from the reset email and
+// signs the account out everywhere.
+func ResetPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Token    string `json:"token" validate:"required"`
+			Password string `json:"password" validate:"required,min=6"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		hashedPassword := utils.PerformHash(body.Password)
+
+		var userID uuid.UUID
+		err := db.Transaction(func(tx *gorm.DB) error {
+			token, err := consumeUserToken(tx, body.Token, models.TokenResetPassword)
+			if err != nil {
+				return err
+			}
+			userID = token.UserID
+
+			now := time.Now()
+			if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+				"password_hash": hashedPassword,
+				"updated_at":    now,
+			}).Error; err != nil {
+				return err
+			}
+			// getting the email proves the address is theirs
+			if err := tx.Model(&models.User{}).
+				Where("id = ? AND email_verified_at IS NULL", userID).
+				Update("email_verified_at", now).Error; err != nil {
+				return err
+			}
+			// a reset is how a taken over account is recovered, so tokens
+			// minted in the meantime go too
+			if err := tx.Where("user_id = ?", userID).Delete(&models.PersonalAccessToken{}).Error; err != nil {
+				return err
+			}
+			return expireUserTokens(tx, userID, models.TokenResetPassword)
+		})
+		if errors.Is(err, errInvalidUserToken) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "This link is invalid or has expired"})
+			return
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+			return
+		}
+
+		if err := revokeAllTokens(ctx, userID); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Password was changed but signing out other devices failed"})
+			return
+		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.PasswordReset,
+			ActorID:    audit.ID(userID),
+			TargetType: audit.TargetUser,
+			TargetID:   audit.ID(userID),
+		})
+		ctx.JSON(http.StatusOK, gin.H{"success": "password reset, please sign in again"})
+	}
+}
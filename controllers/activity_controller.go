@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dipankarupd/text-editor/access"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type recentDocument struct {
+	Document     models.DocResponse `json:"document"`
+	LastOpenedAt *time.Time         `json:"last_opened_at"`
+	LastEditedAt *time.Time         `json:"last_edited_at"`
+}
+
+// GetRecentDocuments lists the documents the user opened or edited last,
+// whoever wrote them. ?limit= is 20 by default and 50 at most.
+func GetRecentDocuments() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "20"))
+		if limit < 1 || limit > 50 {
+			limit = 20
+		}
+
+		var visits []models.DocumentVisit
+		if err := db.Where("user_id = ?", userID).
+			Order("last_activity_at DESC").
+			Limit(limit).
+			Find(&visits).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the documents"})
+			return
+		}
+		docIDs := make([]uuid.UUID, len(visits))
+		for i, v := range visits {
+			docIDs[i] = v.DocumentID
+		}
+		var docs []models.Document
+		if len(docIDs) > 0 {
+			if err := db.Where("id IN ?", docIDs).Find(&docs).Error; err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the documents"})
+				return
+			}
+		}
+
+		// people lose access when they leave a workspace; those visits are
+		// dropped so the list fills up with other documents next time
+		byID := make(map[uuid.UUID]models.Document, len(docs))
+		var stale []uuid.UUID
+		for _, d := range docs {
+			level, err := access.DocumentLevel(userID, &d)
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			if level < access.Read {
+				stale = append(stale, d.ID)
+				continue
+			}
+			byID[d.ID] = d
+		}
+		if len(stale) > 0 {
+			db.Where("user_id = ? AND document_id IN ?", userID, stale).Delete(&models.DocumentVisit{})
+		}
+
+		visible := make([]models.Document, 0, len(byID))
+		for _, v := range visits {
+			if d, ok := byID[v.DocumentID]; ok {
+				visible = append(visible, d)
+			}
+		}
+		docResponses, err := toDocResponses(visible)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the authors"})
+			return
+		}
+
+		recent := make([]recentDocument, 0, len(docResponses))
+		i := 0
+		for _, v := range visits {
+			if _, ok := byID[v.DocumentID]; !ok {
+				continue
+			}
+			recent = append(recent, recentDocument{
+				Document:     docResponses[i],
+				LastOpenedAt: v.LastOpenedAt,
+				LastEditedAt: v.LastEditedAt,
+			})
+			i++
+		}
+		ctx.JSON(http.StatusOK, recent)
+	}
+}
+
+type contribution struct {
+	User          *models.Author `json:"user"`
+	Edits         int64          `json:"edits"`
+	CharsInserted int64          `json:"chars_inserted"`
+	CharsDeleted  int64          `json:"chars_deleted"`
+	FirstEditAt   time.Time      `json:"first_edit_at"`
+	LastEditAt    time.Time      `json:"last_edit_at"`
+}
+
+// GetDocumentContributors sums up who edited the document and how much,
+// from its edit history. Undo and redo count as edits too.
+func GetDocumentContributors() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		doc, ok := loadDocument(ctx, userID, access.Read)
+		if !ok {
+			return
+		}
+
+		var rows []struct {
+			UserID        *uuid.UUID
+			Edits         int64
+			CharsInserted int64
+			CharsDeleted  int64
+			FirstEditAt   time.Time
+			LastEditAt    time.Time
+		}
+		err := db.Raw(`
+			SELECT o.user_id,
+				count(*) AS edits,
+				coalesce(sum(s.inserted), 0)::bigint AS chars_inserted,
+				coalesce(sum(s.deleted), 0)::bigint AS chars_deleted,
+				min(o.created_at) AS first_edit_at,
+				max(o.created_at) AS last_edit_at
+			FROM document_ops o
+			LEFT JOIN LATERAL (
+				SELECT
+					-- UTF-16 units like delete counts: characters outside the BMP count twice
+					sum(length(op->>'insert') + length(regexp_replace(op->>'insert', '[^\U00010000-\U0010FFFF]', '', 'g')))
+						FILTER (WHERE jsonb_typeof(op->'insert') = 'string') AS inserted,
+					sum((op->>'delete')::bigint) AS deleted
+				FROM jsonb_array_elements(o.delta->'ops') op
+			) s ON true
+			WHERE o.document_id = ?
+			GROUP BY o.user_id
+			ORDER BY edits DESC
+		`, doc.ID).Scan(&rows).Error
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the contributors"})
+			return
+		}
+
+		ids := make([]uuid.UUID, 0, len(rows))
+		for _, r := range rows {
+			if r.UserID != nil {
+				ids = append(ids, *r.UserID)
+			}
+		}
+		var users []models.User
+		if len(ids) > 0 {
+			if err := db.Select("id", "name").Where("id IN ?", ids).Find(&users).Error; err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the contributors"})
+				return
+			}
+		}
+		names := make(map[uuid.UUID]string, len(users))
+		for _, u := range users {
+			names[u.ID] = u.Name
+		}
+
+		// edits by deleted users, or from before edits were attributed,
+		// come back with a null user
+		contributions := make([]contribution, len(rows))
+		for i, r := range rows {
+			contributions[i] = contribution{
+				Edits:         r.Edits,
+				CharsInserted: r.CharsInserted,
+				CharsDeleted:  r.CharsDeleted,
+				FirstEditAt:   r.FirstEditAt,
+				LastEditAt:    r.LastEditAt,
+			}
+			if r.UserID != nil {
+				contributions[i].User = &models.Author{ID: *r.UserID, Name: names[*r.UserID]}
+			}
+		}
+		ctx.JSON(http.StatusOK, contributions)
+	}
+}
@@ -0,0 +1,463 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dipankarupd/text-editor/audit"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/notify"
+	"github.com/dipankarupd/text-editor/webhooks"
+	"github.com/dipankarupd/text-editor/ws"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// adminUser is a user as admins see it, with what they store.
+type adminUser struct {
+	models.User
+	DocumentCount int64 `json:"document_count"`
+	StorageBytes  int64 `json:"storage_bytes"` // document content plus edit history
+}
+
+// adminUsersQuery selects users with their document count and storage.
+func adminUsersQuery() *gorm.DB {
+	return db.Table("users").
+		Select("users.*, docs.document_count, docs.content_bytes + ops.history_bytes AS storage_bytes").
+		Joins(`LEFT JOIN LATERAL (
+			SELECT count(*) AS document_count, coalesce(sum(pg_column_size(d.content)), 0)::bigint AS content_bytes
+			FROM documents d WHERE d.author_id = users.id
+		) docs ON true`).
+		Joins(`LEFT JOIN LATERAL (
+			SELECT coalesce(sum(pg_column_size(o.delta) + pg_column_size(o.inverse)), 0)::bigint AS history_bytes
+			FROM document_ops o JOIN documents d ON d.id = o.document_id WHERE d.author_id = users.id
+		) ops ON true`)
+}
+
+// BootstrapAdmins makes the verified accounts listed in ADMIN_EMAILS admins,
+// so a new install has someone to hand out the role.
+func BootstrapAdmins() {
+	var emails []string
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	if len(emails) == 0 {
+		return
+	}
+
+	// only verified addresses, or anyone could sign up as the admin first
+	res := db.Model(&models.User{}).
+		Where("lower(email) IN ? AND email_verified_at IS NOT NULL AND role <> ?", emails, models.RoleAdmin).
+		Update("role", models.RoleAdmin)
+	if res.Error != nil {
+		log.Printf("Failed to set up admins from ADMIN_EMAILS: %v", res.Error)
+		return
+	}
+	if res.RowsAffected > 0 {
+		log.Printf("Made %d account(s) from ADMIN_EMAILS admin", res.RowsAffected)
+	}
+}
+
+// RequireAdmin lets only admins through. The role is read from the database
+// rather than the token, so taking it away works right away.
+func RequireAdmin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			ctx.Abort()
+			return
+		}
+
+		var user models.User
+		if err := db.Select("role", "disabled_at").First(&user, "id = ?", userID).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
+			ctx.Abort()
+			return
+		}
+		if user.Role != models.RoleAdmin || user.DisabledAt != nil {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// loadTargetUser loads the user in the :id parameter, writing the error
+// response itself.
+func loadTargetUser(ctx *gin.Context) (models.User, bool) {
+	var user models.User
+	userID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return user, false
+	}
+	err = db.First(&user, "id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return user, false
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return user, false
+	}
+	return user, true
+}
+
+// notSelf stops admins from locking themselves out.
+func notSelf(ctx *gin.Context, target models.User, msg string) bool {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		return false
+	}
+	if userID == target.ID {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return false
+	}
+	return true
+}
+
+// pageParams reads ?page= and ?per_page=, 20 a page by default and 100 at
+// most.
+func pageParams(ctx *gin.Context) (page, perPage int) {
+	page, _ = strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ = strconv.Atoi(ctx.DefaultQuery("per_page", "20"))
+	if perPage < 1 || perPage > 100 {
+		perPage = 20
+	}
+	return page, perPage
+}
+
+// GetUsers lists users a page at a time. ?q= searches names and emails,
+// ?role= and ?disabled=true|false filter.
+func GetUsers() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		page, perPage := pageParams(ctx)
+
+		filter := func(query *gorm.DB) *gorm.DB {
+			if q := ctx.Query("q"); q != "" {
+				pattern := "%" + escapeLike(q) + "%"
+				query = query.Where("(users.email ILIKE ? OR users.name ILIKE ?)", pattern, pattern)
+			}
+			if role := ctx.Query("role"); role != "" {
+				query = query.Where("users.role = ?", role)
+			}
+			switch ctx.Query("disabled") {
+			case "true":
+				query = query.Where("users.disabled_at IS NOT NULL")
+			case "false":
+				query = query.Where("users.disabled_at IS NULL")
+			}
+			return query
+		}
+
+		var total int64
+		if err := filter(db.Model(&models.User{})).Count(&total).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the users"})
+			return
+		}
+
+		users := []adminUser{}
+		err := filter(adminUsersQuery()).
+			Order("users.created_at DESC").
+			Offset((page - 1) * perPage).
+			Limit(perPage).
+			Scan(&users).Error
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the users"})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"users":    users,
+			"total":    total,
+			"page":     page,
+			"per_page": perPage,
+		})
+	}
+}
+
+// GetAdminUser shows one user with their usage and how many devices and
+// access tokens they have signed in.
+func GetAdminUser() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		target, ok := loadTargetUser(ctx)
+		if !ok {
+			return
+		}
+
+		var user adminUser
+		if err := adminUsersQuery().Where("users.id = ?", target.ID).Scan(&user).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the user"})
+			return
+		}
+		var sessions, tokens int64
+		if err := db.Model(&models.Session{}).
+			Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", target.ID, time.Now()).
+			Count(&sessions).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the user"})
+			return
+		}
+		if err := db.Model(&models.PersonalAccessToken{}).Where("user_id = ?", target.ID).Count(&tokens).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the user"})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"user":            user,
+			"active_sessions": sessions,
+			"access_tokens":   tokens,
+		})
+	}
+}
+
+// DisableUser stops the user from signing in and signs them out everywhere.
+// Their documents stay as they are.
+func DisableUser() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		target, ok := loadTargetUser(ctx)
+		if !ok {
+			return
+		}
+		if !notSelf(ctx, target, "You can't disable your own account") {
+			return
+		}
+
+		if target.DisabledAt == nil {
+			now := time.Now()
+			if err := db.Model(&target).Update("disabled_at", now).Error; err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable the user"})
+				return
+			}
+		}
+		if err := revokeAllTokens(ctx, target.ID); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "User was disabled but signing them out failed"})
+			return
+		}
+		audit.Record(ctx, audit.Event{Action: audit.UserDisabled, TargetType: audit.TargetUser, TargetID: audit.ID(target.ID)})
+		ctx.JSON(http.StatusOK, target)
+	}
+}
+
+// EnableUser lets a disabled user sign in again.
+func EnableUser() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		target, ok := loadTargetUser(ctx)
+		if !ok {
+			return
+		}
+		if err := db.Model(&target).Update("disabled_at", nil).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable the user"})
+			return
+		}
+		audit.Record(ctx, audit.Event{Action: audit.UserEnabled, TargetType: audit.TargetUser, TargetID: audit.ID(target.ID)})
+		ctx.JSON(http.StatusOK, target)
+	}
+}
+
+// ForceLogoutUser signs the user out on every device.
+func ForceLogoutUser() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		target, ok := loadTargetUser(ctx)
+		if !ok {
+			return
+		}
+		if err := revokeAllTokens(ctx, target.ID); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign the user out"})
+			return
+		}
+		audit.Record(ctx, audit.Event{Action: audit.UserLoggedOutByAdmin, TargetType: audit.TargetUser, TargetID: audit.ID(target.ID)})
+		ctx.JSON(http.StatusOK, gin.H{"success": "user signed out everywhere"})
+	}
+}
+
+// UpdateUserRole makes a user an admin or takes the role away.
+func UpdateUserRole() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Role string `json:"role" validate:"required,oneof=user admin"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		target, ok := loadTargetUser(ctx)
+		if !ok {
+			return
+		}
+		if !notSelf(ctx, target, "You can't change your own role") {
+			return
+		}
+		oldRole := target.Role
+		if err := db.Model(&target).Update("role", body.Role).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the role"})
+			return
+		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.UserRoleChanged,
+			TargetType: audit.TargetUser,
+			TargetID:   audit.ID(target.ID),
+			Metadata:   map[string]interface{}{"from": oldRole, "to": body.Role},
+		})
+		ctx.JSON(http.StatusOK, target)
+	}
+}
+
+// loadRecipient loads the user documents are transferred to, writing the
+// error response itself.
+func loadRecipient(ctx *gin.Context) (models.User, bool) {
+	var body struct {
+		UserID string `json:"user_id" validate:"required,uuid"`
+	}
+	var recipient models.User
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+		return recipient, false
+	}
+	if err := validator.New().Struct(body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return recipient, false
+	}
+
+	err := db.First(&recipient, "id = ?", body.UserID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "No user with that user_id"})
+		return recipient, false
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return recipient, false
+	}
+	if recipient.DisabledAt != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Can't transfer documents to a disabled account"})
+		return recipient, false
+	}
+	return recipient, true
+}
+
+// TransferDocument gives a document to another user.
+func TransferDocument() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		docID, err := uuid.Parse(ctx.Param("id"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+			return
+		}
+		recipient, ok := loadRecipient(ctx)
+		if !ok {
+			return
+		}
+
+		var doc models.Document
+		err = db.First(&doc, "id = ?", docID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		oldAuthor := doc.AuthorID
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&doc).Updates(map[string]interface{}{
+				"author_id":  recipient.ID,
+				"updated_at": time.Now(),
+			}).Error; err != nil {
+				return err
+			}
+			return webhooks.DropForTransfer(tx, []uuid.UUID{doc.ID}, recipient.ID)
+		})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer the document"})
+			return
+		}
+		ws.RecheckAccess(oldAuthor)
+		audit.Record(ctx, audit.Event{
+			Action:     audit.DocumentTransferred,
+			TargetType: audit.TargetDocument,
+			TargetID:   audit.ID(doc.ID),
+			Metadata:   map[string]interface{}{"from": oldAuthor, "to": recipient.ID},
+		})
+		notify.Send(ctx, notify.Event{
+			Type:       notify.DocumentTransferred,
+			UserID:     recipient.ID,
+			DocumentID: &doc.ID,
+			Data:       map[string]interface{}{"title": doc.Title},
+		})
+		ctx.JSON(http.StatusOK, doc)
+	}
+}
+
+// TransferUserDocuments gives all of a user's documents to another user,
+// e.g. before their account is deleted.
+func TransferUserDocuments() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		target, ok := loadTargetUser(ctx)
+		if !ok {
+			return
+		}
+		recipient, ok := loadRecipient(ctx)
+		if !ok {
+			return
+		}
+		if recipient.ID == target.ID {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Can't transfer documents to the same user"})
+			return
+		}
+
+		// one event per document, so each shows up in its own activity
+		var docIDs []uuid.UUID
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.Document{}).Where("author_id = ?", target.ID).Pluck("id", &docIDs).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Document{}).Where("id IN ?", docIDs).Updates(map[string]interface{}{
+				"author_id":  recipient.ID,
+				"updated_at": time.Now(),
+			}).Error; err != nil {
+				return err
+			}
+			return webhooks.DropForTransfer(tx, docIDs, recipient.ID)
+		})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer the documents"})
+			return
+		}
+		ws.RecheckAccess(target.ID)
+		for _, id := range docIDs {
+			audit.Record(ctx, audit.Event{
+				Action:     audit.DocumentTransferred,
+				TargetType: audit.TargetDocument,
+				TargetID:   audit.ID(id),
+				Metadata:   map[string]interface{}{"from": target.ID, "to": recipient.ID},
+			})
+		}
+		if len(docIDs) > 0 {
+			notify.Send(ctx, notify.Event{
+				Type:   notify.DocumentTransferred,
+				UserID: recipient.ID,
+				Data:   map[string]interface{}{"count": len(docIDs), "from": target.Name},
+			})
+		}
+		ctx.JSON(http.StatusOK, gin.H{"success": "documents transferred", "transferred": len(docIDs)})
+	}
+}
@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dipankarupd/text-editor/access"
+	"github.com/dipankarupd/text-editor/audit"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type auditEventResponse struct {
+	models.AuditEvent
+	Actor *models.Author `json:"actor"`
+}
+
+// auditFilters applies the query string filters shared by the audit
+// endpoints: ?action= (a trailing * matches a prefix, e.g. auth.*),
+// ?actor_id=, ?since= and ?until= (RFC 3339). It writes a 400 and returns
+// false for a malformed filter.
+func auditFilters(ctx *gin.Context, query *gorm.DB) (*gorm.DB, bool) {
+	if action := ctx.Query("action"); action != "" {
+		if prefix, ok := strings.CutSuffix(action, "*"); ok {
+			query = query.Where("action LIKE ?", escapeLike(prefix)+"%")
+		} else {
+			query = query.Where("action = ?", action)
+		}
+	}
+	if raw := ctx.Query("actor_id"); raw != "" {
+		actorID, err := uuid.Parse(raw)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid actor_id"})
+			return nil, false
+		}
+		query = query.Where("actor_id = ?", actorID)
+	}
+	for param, cond := range map[string]string{"since": "created_at >= ?", "until": "created_at < ?"} {
+		raw := ctx.Query(param)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + ", use RFC 3339"})
+			return nil, false
+		}
+		query = query.Where(cond, t)
+	}
+	return query, true
+}
+
+// listAuditEvents writes a page of the events matching the query, newest
+// first, with their actors' names.
+func listAuditEvents(ctx *gin.Context, query *gorm.DB, showClient bool) {
+	page, perPage := pageParams(ctx)
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Model(&models.AuditEvent{}).Count(&total).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the events"})
+		return
+	}
+	var events []models.AuditEvent
+	err := query.Order("created_at DESC").
+		Offset((page - 1) * perPage).
+		Limit(perPage).
+		Find(&events).Error
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the events"})
+		return
+	}
+
+	actorIDs := make([]uuid.UUID, 0, len(events))
+	for _, e := range events {
+		if e.ActorID != nil {
+			actorIDs = append(actorIDs, *e.ActorID)
+		}
+	}
+	var actors []models.User
+	if len(actorIDs) > 0 {
+		if err := db.Select("id", "name").Where("id IN ?", actorIDs).Find(&actors).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the actors"})
+			return
+		}
+	}
+	names := make(map[uuid.UUID]string, len(actors))
+	for _, a := range actors {
+		names[a.ID] = a.Name
+	}
+
+	response := make([]auditEventResponse, len(events))
+	for i, e := range events {
+		if !showClient {
+			e.IPAddress, e.UserAgent = "", ""
+		}
+		response[i] = auditEventResponse{AuditEvent: e}
+		// deleted users keep their id but lose their name
+		if e.ActorID != nil {
+			response[i].Actor = &models.Author{ID: *e.ActorID, Name: names[*e.ActorID]}
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"events":   response,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+// GetAuditEvents is the full audit log for admins. Besides the shared
+// filters it takes ?target_type=, ?target_id= and ?ip=.
+func GetAuditEvents() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		query, ok := auditFilters(ctx, db.Model(&models.AuditEvent{}))
+		if !ok {
+			return
+		}
+		if targetType := ctx.Query("target_type"); targetType != "" {
+			query = query.Where("target_type = ?", targetType)
+		}
+		if raw := ctx.Query("target_id"); raw != "" {
+			targetID, err := uuid.Parse(raw)
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target_id"})
+				return
+			}
+			query = query.Where("target_id = ?", targetID)
+		}
+		if ip := ctx.Query("ip"); ip != "" {
+			query = query.Where("ip_address = ?", ip)
+		}
+		listAuditEvents(ctx, query, true)
+	}
+}
+
+// GetDocumentActivity lists what happened to a document, for anyone who can
+// read it. Where people connected from is only shown to admins.
+func GetDocumentActivity() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		doc, ok := loadDocument(ctx, userID, access.Read)
+		if !ok {
+			return
+		}
+
+		query, ok := auditFilters(ctx, db.Model(&models.AuditEvent{}).
+			Where("target_type = ? AND target_id = ?", audit.TargetDocument, doc.ID))
+		if !ok {
+			return
+		}
+		listAuditEvents(ctx, query, false)
+	}
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dipankarupd/text-editor/access"
+	"github.com/dipankarupd/text-editor/middlewares"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/ot"
+	"github.com/dipankarupd/text-editor/ws"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// documentETag fingerprints what a client sees of the document. The revision
+// covers the content and updated_at the description, tags and properties, so
+// it changes with every edit, rename or transfer. updated_at is cut to the
+// microseconds postgres keeps.
+func documentETag(doc *models.Document) string {
+	workspace := ""
+	if doc.WorkspaceID != nil {
+		workspace = doc.WorkspaceID.String()
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%s\x00%s\x00%s\x00%d", doc.ID, doc.Revision, doc.Title, doc.AuthorID, workspace, doc.UpdatedAt.UnixMicro())))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagMatches checks an If-Match or If-None-Match header, a list of tags or
+// "*", against the current tag. Weak tags compare by their value, and the tag
+// of the gzipped response stands for the same document.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if gzipped, ok := strings.CutSuffix(candidate, middlewares.GzipETagSuffix+`"`); ok {
+			candidate = gzipped + `"`
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateDocumentContent edits a document over plain HTTP, through the same
+// path as live edits, and broadcasts the change to the open editors. Send
+// either a "delta" made against "base_revision" (the latest if left out),
+// which is rebased over anything newer, or the whole new "content" with an
+// If-Match header holding the document's ETag.
+func UpdateDocumentContent() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			BaseRevision *int64          `json:"base_revision"`
+			Delta        json.RawMessage `json:"delta"`
+			Content      json.RawMessage `json:"content"`
+		}
+		if err := ctx.ShouldBindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if (body.Delta == nil) == (body.Content == nil) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Send either delta or content"})
+			return
+		}
+
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		doc, ok := loadDocument(ctx, userID, access.Write)
+		if !ok {
+			return
+		}
+
+		var (
+			results []ws.ChangeResult
+			updated *models.Document
+			err     error
+		)
+		if body.Delta != nil {
+			change, parseErr := ot.Parse(body.Delta)
+			if parseErr != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delta"})
+				return
+			}
+			base := ws.LatestRevision
+			if body.BaseRevision != nil {
+				if *body.BaseRevision < 0 {
+					ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid base_revision"})
+					return
+				}
+				base = *body.BaseRevision
+			}
+			results, updated, err = ws.ApplyChanges(doc.ID, &userID, base, []ot.Delta{change}, ws.SourceAPI)
+		} else {
+			ifMatch := ctx.GetHeader("If-Match")
+			if ifMatch == "" {
+				ctx.JSON(http.StatusPreconditionRequired, gin.H{"error": "Replacing the content needs an If-Match header with the document's ETag"})
+				return
+			}
+			content, parseErr := ot.Parse(body.Content)
+			if parseErr != nil || !content.IsDocument() {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "content must be a document: inserts only"})
+				return
+			}
+			results, updated, err = ws.ReplaceContent(doc.ID, &userID, content, func(current *models.Document) bool {
+				return etagMatches(ifMatch, documentETag(current))
+			})
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, ws.ErrPreconditionFailed):
+				ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": "The document changed since you read it"})
+			case errors.Is(err, ws.ErrRevisionAhead):
+				ctx.JSON(http.StatusConflict, gin.H{"error": "base_revision is newer than the document"})
+			case errors.Is(err, ws.ErrInvalidDocument):
+				ctx.JSON(http.StatusConflict, gin.H{"error": "Document content can't be merged"})
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			default:
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document"})
+			}
+			return
+		}
+		if results[0].Status != ws.ChangeApplied {
+			ctx.JSON(http.StatusConflict, gin.H{"error": results[0].Error, "revision": updated.Revision})
+			return
+		}
+
+		ws.BroadcastChanges(nil, doc.ID.String(), results)
+
+		ctx.Header("ETag", documentETag(updated))
+		ctx.JSON(http.StatusOK, gin.H{
+			"revision": updated.Revision,
+			"content":  updated.Content,
+			"delta":    results[0].Delta,
+		})
+	}
+}
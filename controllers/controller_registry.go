@@ -1,6 +1,12 @@
 package controllers
 
-import "gorm.io/gorm"
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
 
 
 var db *gorm.DB
@@ -8,3 +14,19 @@ func InitControllers(database *gorm.DB) {
 
 	db = database
 }
+
+// currentUserID reads the id set by the auth middleware, writing the error
+// response itself when it's missing.
+func currentUserID(ctx *gin.Context) (uuid.UUID, bool) {
+	userIdVal, exist := ctx.Get("userid")
+	if !exist {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
+		return uuid.Nil, false
+	}
+	userId, ok := userIdVal.(uuid.UUID)
+	if !ok {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
+		return uuid.Nil, false
+	}
+	return userId, true
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dipankarupd/text-editor/access"
+	"github.com/dipankarupd/text-editor/audit"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/ot"
+	"github.com/dipankarupd/text-editor/webhooks"
+	"github.com/dipankarupd/text-editor/ws"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CopyDocument creates a document owned by the caller with the content of
+// another one, optionally as it was at an earlier revision.
+func CopyDocument() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Revision *int64 `json:"revision"`
+		}
+		if err := ctx.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		source, ok := loadDocument(ctx, userID, access.Read)
+		if !ok {
+			return
+		}
+
+		revision := source.Revision
+		content := source.Content
+		if body.Revision != nil && *body.Revision != source.Revision {
+			if *body.Revision < 0 || *body.Revision > source.Revision {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Revision does not exist"})
+				return
+			}
+			revision = *body.Revision
+			var err error
+			content, err = contentAtRevision(*source, revision)
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild the revision"})
+				return
+			}
+		}
+
+		doc := models.Document{
+			ID:                 uuid.New(),
+			AuthorID:           userID,
+			Title:              "Copy of " + source.Title,
+			Description:        source.Description,
+			Content:            content,
+			ForkedFromID:       &source.ID,
+			ForkedFromRevision: &revision,
+			CreatedAt:          time.Now(),
+			UpdatedAt:          time.Now(),
+		}
+		// tags come along; custom properties belong to the source's workspace
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&doc).Error; err != nil {
+				return err
+			}
+			return tx.Exec("INSERT INTO document_tags (document_id, tag_id) SELECT ?, tag_id FROM document_tags WHERE document_id = ?", doc.ID, source.ID).Error
+		})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Document"})
+			return
+		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.DocumentCreated,
+			TargetType: audit.TargetDocument,
+			TargetID:   audit.ID(doc.ID),
+			Metadata:   map[string]interface{}{"copied_from": source.ID, "revision": revision},
+		})
+		webhooks.Dispatch(webhooks.DocumentCreated, &doc, map[string]interface{}{"copied_from": source.ID, "revision": revision})
+
+		ws.RecordOpen(userID, doc.ID)
+
+		docResponses, err := toDocResponses([]models.Document{doc})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch author name"})
+			return
+		}
+		ctx.JSON(http.StatusCreated, docResponses[0])
+	}
+}
+
+// contentAtRevision walks the history back from the current content by
+// applying the stored inverse of every later op.
+func contentAtRevision(doc models.Document, revision int64) (json.RawMessage, error) {
+	content, err := ot.Parse(doc.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []models.DocumentOp
+	if err := db.Where("document_id = ? AND revision > ? AND revision <= ?", doc.ID, revision, doc.Revision).
+		Order("revision DESC").Find(&ops).Error; err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		inverse, err := ot.Parse(op.Inverse)
+		if err != nil {
+			return nil, err
+		}
+		content = content.Compose(inverse)
+	}
+
+	return content.Raw(), nil
+}
@@ -3,15 +3,142 @@ package controllers
 import (
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/dipankarupd/text-editor/access"
+	"github.com/dipankarupd/text-editor/audit"
 	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/webhooks"
+	"github.com/dipankarupd/text-editor/ws"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// loadDocument fetches the document named by the :id param and checks the
+// caller has at least the given access level, writing the error response
+// itself when they don't.
+func loadDocument(ctx *gin.Context, userID uuid.UUID, level access.Level) (*models.Document, bool) {
+	docID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return nil, false
+	}
+
+	var doc models.Document
+	if err := db.First(&doc, "id = ?", docID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		} else {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return nil, false
+	}
+
+	granted, err := access.DocumentLevel(userID, &doc)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+	if granted < level {
+		// POST /documents/:id/access-requests asks the owner to let them in
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error":              "You don't have permission to access this document",
+			"can_request_access": granted < access.Write,
+		})
+		return nil, false
+	}
+
+	return &doc, true
+}
+
+// toDocResponse converts a document, taking the author's and last editor's
+// names from names.
+func toDocResponse(doc models.Document, names map[uuid.UUID]string) models.DocResponse {
+	var forkedFrom *models.ForkOrigin
+	if doc.ForkedFromID != nil {
+		forkedFrom = &models.ForkOrigin{ID: *doc.ForkedFromID}
+		if doc.ForkedFromRevision != nil {
+			forkedFrom.Revision = *doc.ForkedFromRevision
+		}
+	}
+
+	var lastEditedBy *models.Author
+	if doc.LastEditedBy != nil {
+		lastEditedBy = &models.Author{ID: *doc.LastEditedBy, Name: names[*doc.LastEditedBy]}
+	}
+
+	return models.DocResponse{
+		ID: doc.ID,
+		Author: models.Author{
+			ID:   doc.AuthorID,
+			Name: names[doc.AuthorID],
+		},
+		WorkspaceID:  doc.WorkspaceID,
+		Title:        doc.Title,
+		Description:  doc.Description,
+		Tags:         []string{},
+		Properties:   []models.PropertyValue{},
+		Content:      doc.Content,
+		Revision:     doc.Revision,
+		ForkedFrom:   forkedFrom,
+		LastEditedBy: lastEditedBy,
+		LastEditedAt: doc.LastEditedAt,
+		CreatedAt:    doc.CreatedAt,
+		UpdatedAt:    doc.UpdatedAt,
+	}
+}
+
+// toDocResponses converts documents by different authors, looking the author
+// names, tags and properties up a query each.
+func toDocResponses(docs []models.Document) ([]models.DocResponse, error) {
+	authorIDs := make([]uuid.UUID, 0, len(docs))
+	docIDs := make([]uuid.UUID, 0, len(docs))
+	for _, d := range docs {
+		docIDs = append(docIDs, d.ID)
+		authorIDs = append(authorIDs, d.AuthorID)
+		if d.LastEditedBy != nil {
+			authorIDs = append(authorIDs, *d.LastEditedBy)
+		}
+	}
+
+	var authors []models.User
+	if len(authorIDs) > 0 {
+		if err := db.Where("id IN ?", authorIDs).Find(&authors).Error; err != nil {
+			return nil, err
+		}
+	}
+	names := make(map[uuid.UUID]string, len(authors))
+	for _, a := range authors {
+		names[a.ID] = a.Name
+	}
+
+	tags, properties, err := documentMetadata(docIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	docResponses := make([]models.DocResponse, len(docs))
+	for i, d := range docs {
+		docResponses[i] = toDocResponse(d, names)
+		if t, ok := tags[d.ID]; ok {
+			docResponses[i].Tags = t
+		}
+		if p, ok := properties[d.ID]; ok {
+			docResponses[i].Properties = p
+		}
+	}
+	return docResponses, nil
+}
+
+// escapeLike escapes the wildcards in user input used in a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
 
 func CreateDocument() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
@@ -28,6 +155,16 @@ func CreateDocument() gin.HandlerFunc {
 			return
 		} 
 		
+		// the body is optional; without a template the document starts empty
+		var body struct {
+			TemplateID  *uuid.UUID `json:"template_id"`
+			WorkspaceID *uuid.UUID `json:"workspace_id"`
+		}
+		if err := ctx.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+
 		doc := models.Document {
 			ID: uuid.New(),
 			AuthorID: authorId,
@@ -37,10 +174,46 @@ func CreateDocument() gin.HandlerFunc {
 			UpdatedAt: time.Now(),
 		}
 
+		if body.TemplateID != nil {
+			template, err := findTemplate(*body.TemplateID, authorId)
+			if err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					ctx.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+				} else {
+					ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				}
+				return
+			}
+			doc.Title = template.Title
+			doc.Content = template.Content
+		}
+
+		// guests can read a workspace's documents but not add to it
+		if body.WorkspaceID != nil {
+			role, err := access.WorkspaceRole(*body.WorkspaceID, authorId)
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			if role != models.WorkspaceRoleAdmin && role != models.WorkspaceRoleMember {
+				ctx.JSON(http.StatusForbidden, gin.H{"error": "You can't create documents in this workspace"})
+				return
+			}
+			doc.WorkspaceID = body.WorkspaceID
+		}
+
 		if err := db.Create(&doc).Error; err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Document"})
 			return
 		}
+		ws.RecordOpen(authorId, doc.ID)
+		audit.Record(ctx, audit.Event{
+			Action:     audit.DocumentCreated,
+			TargetType: audit.TargetDocument,
+			TargetID:   audit.ID(doc.ID),
+			Metadata:   map[string]interface{}{"template_id": body.TemplateID, "workspace_id": body.WorkspaceID},
+		})
+		webhooks.Dispatch(webhooks.DocumentCreated, &doc, map[string]interface{}{"template_id": body.TemplateID})
 
 		var author models.User
 		if err := db.First(&author, "id = ?", authorId).Error; err != nil {
@@ -48,19 +221,7 @@ func CreateDocument() gin.HandlerFunc {
 			return
 		}
 
-		response := models.DocResponse{
-			ID: doc.ID,
-			Author: models.Author{
-				ID: doc.AuthorID,
-				Name: author.Name,
-			},
-			Title: doc.Title,
-			Content: doc.Content,
-			CreatedAt: doc.CreatedAt,
-			UpdatedAt: doc.UpdatedAt,
-		}
-
-		ctx.JSON(http.StatusCreated, response)
+		ctx.JSON(http.StatusCreated, toDocResponse(doc, map[uuid.UUID]string{author.ID: author.Name}))
 	}
 }
 
@@ -72,44 +233,28 @@ func GetUserDocuments() gin.HandlerFunc {
 			return
 		}
 
-		authorNameVal, nameExist := ctx.Get("name")
-		if !nameExist {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Author name not found"})
-			return
-		}
-
 		authorId, ok := authorIdVal.(uuid.UUID)
 		if !ok {
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
 			return
 		}
 
-		authorName, ok := authorNameVal.(string)
+		query, ok := filterDocuments(ctx, db.Where("author_id = ?", authorId))
 		if !ok {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid author name format"})
 			return
 		}
 
 		var docs []models.Document
-		if err := db.Where("author_id = ?", authorId).Find(&docs).Error; err != nil {
+		if err := query.Find(&docs).Error; err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the documents"})
 			return
 		}
 
 		// Convert to []DocResponse
-		docResponses := make([]models.DocResponse, len(docs))
-		for i, d := range docs {
-			docResponses[i] = models.DocResponse{
-				ID: d.ID,
-				Author: models.Author{
-					ID:   d.AuthorID,
-					Name: authorName,
-				},
-				Title:     d.Title,
-				Content:   d.Content,
-				CreatedAt: d.CreatedAt,
-				UpdatedAt: d.UpdatedAt,
-			}
+		docResponses, err := toDocResponses(docs)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the authors"})
+			return
 		}
 
 		ctx.JSON(http.StatusOK, docResponses)
@@ -118,43 +263,42 @@ func GetUserDocuments() gin.HandlerFunc {
 
 func GetDocumentByID() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		docId := ctx.Param("id")
+		userId, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
 
-		var doc models.Document
+		doc, ok := loadDocument(ctx, userId, access.Read)
+		if !ok {
+			return
+		}
+		// scripts reading with an access token shouldn't fill the user's
+		// recent documents
+		if _, viaToken := ctx.Get("tokenid"); !viaToken {
+			ws.RecordOpen(userId, doc.ID)
+		}
 
-		if err := db.First(&doc, "id = ?", docId).Error; err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the Document"})
+		// clients that have this revision already don't need the content
+		// again
+		etag := documentETag(doc)
+		ctx.Header("ETag", etag)
+		ctx.Header("Cache-Control", "private, no-cache")
+		if match := ctx.GetHeader("If-None-Match"); match != "" && etagMatches(match, etag) {
+			ctx.Status(http.StatusNotModified)
 			return
 		}
-		var author models.User
-		if err := db.First(&author, "id = ?", doc.AuthorID).Error; err != nil {
+
+		docResponses, err := toDocResponses([]models.Document{*doc})
+		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the Author of the document"})
 			return
-		} 
-
-		response := models.DocResponse {
-			ID: doc.ID,
-			Author: models.Author{
-				ID: doc.AuthorID,
-				Name: author.Name,
-			},
-			Title: doc.Title,
-			Content: doc.Content,
-			CreatedAt: doc.CreatedAt,
-			UpdatedAt: doc.UpdatedAt,
 		}
-		ctx.JSON(http.StatusOK, response)
+
+		ctx.JSON(http.StatusOK, docResponses[0])
 	}
 }
 func UpdateDocumentTitle() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		// Parse document ID from URL
-		docIDParam := ctx.Param("id")
-		docID, err := uuid.Parse(docIDParam)
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
-			return
-		}
 		// Bind request body
 		var body struct {
 			Title string `json:"title"`
@@ -163,43 +307,52 @@ func UpdateDocumentTitle() gin.HandlerFunc {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Title is required"})
 			return
 		}
-		// Check if document exists and belongs to the user
-		authorIdVal, exist := ctx.Get("userid")
-		if !exist {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
+		authorID, ok := currentUserID(ctx)
+		if !ok {
 			return
 		}
-		authorID, ok := authorIdVal.(uuid.UUID)
+
+		// owners and workspace members with write access can rename
+		doc, ok := loadDocument(ctx, authorID, access.Write)
 		if !ok {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
 			return
 		}
-		
-		// First check if document exists at all
-		var doc models.Document
-		if err := db.First(&doc, "id = ?", docID).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				ctx.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
-			} else {
-				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+
+		// with If-Match the rename only goes through if nobody changed the
+		// document since the client read it; the row lock keeps it that way
+		ifMatch := ctx.GetHeader("If-Match")
+		var oldTitle string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Omit("content").First(doc, "id = ?", doc.ID).Error; err != nil {
+				return err
 			}
+			if ifMatch != "" && !etagMatches(ifMatch, documentETag(doc)) {
+				return ws.ErrPreconditionFailed
+			}
+			oldTitle = doc.Title
+			doc.Title = body.Title
+			doc.UpdatedAt = time.Now()
+			// only touch the title; content is written concurrently by live edits
+			return tx.Model(doc).Updates(map[string]interface{}{"title": doc.Title, "updated_at": doc.UpdatedAt}).Error
+		})
+		if errors.Is(err, ws.ErrPreconditionFailed) {
+			ctx.Header("ETag", documentETag(doc))
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": "The document changed since you read it"})
 			return
 		}
-		
-		// Then check if user owns the document
-		if doc.AuthorID != authorID {
-			ctx.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to access this document"})
-			return
-		}
-		
-		// Update title and UpdatedAt
-		doc.Title = body.Title
-		doc.UpdatedAt = time.Now()
-		if err := db.Save(&doc).Error; err != nil {
+		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document"})
 			return
 		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.DocumentTitleChanged,
+			TargetType: audit.TargetDocument,
+			TargetID:   audit.ID(doc.ID),
+			Metadata:   map[string]interface{}{"from": oldTitle, "to": doc.Title},
+		})
+		webhooks.Dispatch(webhooks.DocumentTitleChanged, doc, map[string]interface{}{"from": oldTitle, "to": doc.Title})
 		// Return response
+		ctx.Header("ETag", documentETag(doc))
 		ctx.JSON(http.StatusOK, gin.H{
 			"success":   "ok",
 			"new_title": doc.Title,
@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/dipankarupd/text-editor/signing"
+	"github.com/gin-gonic/gin"
+)
+
+// GetJWKS publishes the public keys our tokens are signed with so other
+// services can verify them.
+func GetJWKS() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// new keys are published an hour before use, so a few minutes of
+		// caching never hides a key that's signing tokens
+		ctx.Header("Cache-Control", "public, max-age=300")
+		ctx.JSON(http.StatusOK, signing.JWKS())
+	}
+}
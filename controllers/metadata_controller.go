@@ -0,0 +1,641 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dipankarupd/text-editor/access"
+	"github.com/dipankarupd/text-editor/audit"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/webhooks"
+	"github.com/dipankarupd/text-editor/ws"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxTags          = 20
+	maxTagLength     = 50
+	maxDescription   = 2000
+	maxTextProperty  = 1000
+	maxSelectOptions = 50
+)
+
+// normalizeTags trims and lowercases tags and drops duplicates, writing a 400
+// when one is empty or too long.
+func normalizeTags(ctx *gin.Context, tags []string) ([]string, bool) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || utf8.RuneCountInString(t) > maxTagLength {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Tags must be 1 to %d characters", maxTagLength)})
+			return nil, false
+		}
+		if !seen[t] {
+			seen[t] = true
+			normalized = append(normalized, t)
+		}
+	}
+	if len(normalized) > maxTags {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A document can have at most %d tags", maxTags)})
+		return nil, false
+	}
+	return normalized, true
+}
+
+// propertyValue checks a value against the property's type and returns it the
+// way it's stored: numbers as numbers, everything else as a string.
+func propertyValue(prop *models.WorkspaceProperty, raw json.RawMessage) (json.RawMessage, error) {
+	if prop.Type == models.PropertyNumber {
+		var n float64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return nil, fmt.Errorf("%s must be a number", prop.Name)
+		}
+		return json.Marshal(n)
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("%s must be a string", prop.Name)
+	}
+	switch prop.Type {
+	case models.PropertyText:
+		s = strings.TrimSpace(s)
+		if utf8.RuneCountInString(s) > maxTextProperty {
+			return nil, fmt.Errorf("%s can be at most %d characters", prop.Name, maxTextProperty)
+		}
+	case models.PropertyDate:
+		if _, err := time.Parse(time.DateOnly, s); err != nil {
+			return nil, fmt.Errorf("%s must be a date like 2025-07-16", prop.Name)
+		}
+	case models.PropertySelect:
+		if !slices.Contains(prop.Options, s) {
+			return nil, fmt.Errorf("%s must be one of: %s", prop.Name, strings.Join(prop.Options, ", "))
+		}
+	}
+	return json.Marshal(s)
+}
+
+// documentMetadata looks up the tags and custom property values of documents,
+// keyed by document.
+func documentMetadata(docIDs []uuid.UUID) (map[uuid.UUID][]string, map[uuid.UUID][]models.PropertyValue, error) {
+	tags := make(map[uuid.UUID][]string)
+	properties := make(map[uuid.UUID][]models.PropertyValue)
+	if len(docIDs) == 0 {
+		return tags, properties, nil
+	}
+
+	var tagRows []struct {
+		DocumentID uuid.UUID
+		Name       string
+	}
+	err := db.Table("document_tags dt").
+		Select("dt.document_id, t.name").
+		Joins("JOIN tags t ON t.id = dt.tag_id").
+		Where("dt.document_id IN ?", docIDs).
+		Order("t.name").
+		Scan(&tagRows).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, r := range tagRows {
+		tags[r.DocumentID] = append(tags[r.DocumentID], r.Name)
+	}
+
+	var propertyRows []struct {
+		DocumentID uuid.UUID
+		ID         uuid.UUID
+		Name       string
+		Type       string
+		Value      json.RawMessage
+	}
+	err = db.Table("document_property_values v").
+		Select("v.document_id, p.id, p.name, p.type, v.value").
+		Joins("JOIN workspace_properties p ON p.id = v.property_id").
+		Where("v.document_id IN ?", docIDs).
+		Order("p.created_at").
+		Scan(&propertyRows).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, r := range propertyRows {
+		properties[r.DocumentID] = append(properties[r.DocumentID], models.PropertyValue{ID: r.ID, Name: r.Name, Type: r.Type, Value: r.Value})
+	}
+	return tags, properties, nil
+}
+
+// filterDocuments narrows a document listing to the documents with every
+// ?tag= given and the custom property values in ?property[Name]=value.
+func filterDocuments(ctx *gin.Context, query *gorm.DB) (*gorm.DB, bool) {
+	if tags := ctx.QueryArray("tag"); len(tags) > 0 {
+		tags, ok := normalizeTags(ctx, tags)
+		if !ok {
+			return nil, false
+		}
+		query = query.Where(`(SELECT count(*) FROM document_tags dt JOIN tags t ON t.id = dt.tag_id
+			WHERE dt.document_id = documents.id AND t.name IN ?) = ?`, tags, len(tags))
+	}
+
+	for name, value := range ctx.QueryMap("property") {
+		// values are strings except for numbers, which can be written any way
+		match := "v.value = to_jsonb(?::text)"
+		args := []interface{}{name, value}
+		if n, err := strconv.ParseFloat(value, 64); err == nil && !math.IsNaN(n) && !math.IsInf(n, 0) {
+			match = "(v.value = to_jsonb(?::text) OR v.value = ?::jsonb)"
+			args = append(args, strconv.FormatFloat(n, 'f', -1, 64))
+		}
+		query = query.Where(`EXISTS (SELECT 1 FROM document_property_values v JOIN workspace_properties p ON p.id = v.property_id
+			WHERE v.document_id = documents.id AND lower(p.name) = lower(?) AND `+match+`)`, args...)
+	}
+	return query, true
+}
+
+// setDocumentTags replaces the tags of a document, creating the ones nobody
+// used before.
+func setDocumentTags(tx *gorm.DB, docID uuid.UUID, names []string) error {
+	if err := tx.Where("document_id = ?", docID).Delete(&models.DocumentTag{}).Error; err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	newTags := make([]models.Tag, len(names))
+	for i, name := range names {
+		newTags[i] = models.Tag{ID: uuid.New(), Name: name}
+	}
+	if err := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "name"}}, DoNothing: true}).Create(&newTags).Error; err != nil {
+		return err
+	}
+	var tagIDs []uuid.UUID
+	if err := tx.Model(&models.Tag{}).Where("name IN ?", names).Pluck("id", &tagIDs).Error; err != nil {
+		return err
+	}
+	links := make([]models.DocumentTag, len(tagIDs))
+	for i, id := range tagIDs {
+		links[i] = models.DocumentTag{DocumentID: docID, TagID: id}
+	}
+	return tx.Create(&links).Error
+}
+
+// UpdateDocumentMetadata changes the description, tags and custom properties
+// of a document. Fields left out stay as they are; "tags" replaces all of
+// them, and a property set to null is cleared. Like a rename it can be made
+// conditional with If-Match.
+func UpdateDocumentMetadata() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Description *string                    `json:"description"`
+			Tags        *[]string                  `json:"tags"`
+			Properties  map[string]json.RawMessage `json:"properties"`
+		}
+		if err := ctx.ShouldBindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if body.Description == nil && body.Tags == nil && len(body.Properties) == 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Send a description, tags or properties"})
+			return
+		}
+		if body.Description != nil {
+			*body.Description = strings.TrimSpace(*body.Description)
+			if utf8.RuneCountInString(*body.Description) > maxDescription {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("The description can be at most %d characters", maxDescription)})
+				return
+			}
+		}
+		var tags []string
+		if body.Tags != nil {
+			var ok bool
+			if tags, ok = normalizeTags(ctx, *body.Tags); !ok {
+				return
+			}
+		}
+
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		doc, ok := loadDocument(ctx, userID, access.Write)
+		if !ok {
+			return
+		}
+
+		// properties are named case-insensitively, as they're unique that way
+		values := make(map[uuid.UUID]json.RawMessage, len(body.Properties))
+		changedProperties := make(map[string]json.RawMessage, len(body.Properties))
+		if len(body.Properties) > 0 {
+			if doc.WorkspaceID == nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Only workspace documents have custom properties"})
+				return
+			}
+			var defined []models.WorkspaceProperty
+			if err := db.Where("workspace_id = ?", *doc.WorkspaceID).Find(&defined).Error; err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			for name, raw := range body.Properties {
+				i := slices.IndexFunc(defined, func(p models.WorkspaceProperty) bool { return strings.EqualFold(p.Name, name) })
+				if i < 0 {
+					ctx.JSON(http.StatusBadRequest, gin.H{"error": "The workspace has no property " + name})
+					return
+				}
+				prop := &defined[i]
+				if string(raw) == "null" {
+					values[prop.ID] = nil
+					changedProperties[prop.Name] = nil
+					continue
+				}
+				value, err := propertyValue(prop, raw)
+				if err != nil {
+					ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				values[prop.ID] = value
+				changedProperties[prop.Name] = value
+			}
+		}
+
+		ifMatch := ctx.GetHeader("If-Match")
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(doc, "id = ?", doc.ID).Error; err != nil {
+				return err
+			}
+			if ifMatch != "" && !etagMatches(ifMatch, documentETag(doc)) {
+				return ws.ErrPreconditionFailed
+			}
+
+			doc.UpdatedAt = time.Now()
+			updates := map[string]interface{}{"updated_at": doc.UpdatedAt}
+			if body.Description != nil {
+				doc.Description = *body.Description
+				updates["description"] = doc.Description
+			}
+			if err := tx.Model(doc).Updates(updates).Error; err != nil {
+				return err
+			}
+
+			if body.Tags != nil {
+				if err := setDocumentTags(tx, doc.ID, tags); err != nil {
+					return err
+				}
+			}
+			for propertyID, value := range values {
+				if value == nil {
+					err := tx.Where("document_id = ? AND property_id = ?", doc.ID, propertyID).Delete(&models.DocumentPropertyValue{}).Error
+					if err != nil {
+						return err
+					}
+					continue
+				}
+				err := tx.Clauses(clause.OnConflict{
+					Columns:   []clause.Column{{Name: "document_id"}, {Name: "property_id"}},
+					DoUpdates: clause.AssignmentColumns([]string{"value"}),
+				}).Create(&models.DocumentPropertyValue{DocumentID: doc.ID, PropertyID: propertyID, Value: value}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if errors.Is(err, ws.ErrPreconditionFailed) {
+			ctx.Header("ETag", documentETag(doc))
+			ctx.JSON(http.StatusPreconditionFailed, gin.H{"error": "The document changed since you read it"})
+			return
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document"})
+			return
+		}
+
+		changes := map[string]interface{}{}
+		if body.Description != nil {
+			changes["description"] = doc.Description
+		}
+		if body.Tags != nil {
+			changes["tags"] = tags
+		}
+		if len(changedProperties) > 0 {
+			changes["properties"] = changedProperties
+		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.DocumentMetadataChanged,
+			TargetType: audit.TargetDocument,
+			TargetID:   audit.ID(doc.ID),
+			Metadata:   changes,
+		})
+		webhooks.Dispatch(webhooks.DocumentMetadataChanged, doc, changes)
+
+		docResponses, err := toDocResponses([]models.Document{*doc})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the Author of the document"})
+			return
+		}
+		ctx.Header("ETag", documentETag(doc))
+		ctx.JSON(http.StatusOK, docResponses[0])
+	}
+}
+
+// GetWorkspaceTags lists the tags used in a workspace with how many of its
+// documents have each.
+func GetWorkspaceTags() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		workspace, _, ok := loadWorkspace(ctx, userID)
+		if !ok {
+			return
+		}
+
+		tags := []struct {
+			Name      string `json:"name"`
+			Documents int64  `json:"documents"`
+		}{}
+		err := db.Table("document_tags dt").
+			Select("t.name, count(*) AS documents").
+			Joins("JOIN tags t ON t.id = dt.tag_id").
+			Joins("JOIN documents d ON d.id = dt.document_id").
+			Where("d.workspace_id = ?", workspace.ID).
+			Group("t.name").
+			Order("documents DESC, t.name").
+			Scan(&tags).Error
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the tags"})
+			return
+		}
+		ctx.JSON(http.StatusOK, tags)
+	}
+}
+
+// normalizeOptions trims the options of a select and checks there's at least
+// one and no duplicates, writing a 400 otherwise.
+func normalizeOptions(ctx *gin.Context, options []string) ([]string, bool) {
+	normalized := make([]string, 0, len(options))
+	for _, o := range options {
+		o = strings.TrimSpace(o)
+		if o == "" || utf8.RuneCountInString(o) > 100 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Options must be 1 to 100 characters"})
+			return nil, false
+		}
+		if slices.Contains(normalized, o) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Duplicate option " + o})
+			return nil, false
+		}
+		normalized = append(normalized, o)
+	}
+	if len(normalized) == 0 || len(normalized) > maxSelectOptions {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A select needs 1 to %d options", maxSelectOptions)})
+		return nil, false
+	}
+	return normalized, true
+}
+
+// touchPropertyDocuments bumps the documents holding a value of the property
+// so their ETag changes with its name or options.
+func touchPropertyDocuments(tx *gorm.DB, propertyID uuid.UUID) error {
+	return tx.Model(&models.Document{}).
+		Where("id IN (SELECT document_id FROM document_property_values WHERE property_id = ?)", propertyID).
+		Update("updated_at", time.Now()).Error
+}
+
+func isDuplicateKey(err error) bool {
+	return errors.Is(err, gorm.ErrDuplicatedKey) || (err != nil && strings.Contains(err.Error(), "duplicate key"))
+}
+
+func GetWorkspaceProperties() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		workspace, _, ok := loadWorkspace(ctx, userID)
+		if !ok {
+			return
+		}
+
+		properties := []models.WorkspaceProperty{}
+		if err := db.Where("workspace_id = ?", workspace.ID).Order("created_at").Find(&properties).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the properties"})
+			return
+		}
+		ctx.JSON(http.StatusOK, properties)
+	}
+}
+
+// CreateWorkspaceProperty lets workspace admins define a custom property;
+// selects need their options.
+func CreateWorkspaceProperty() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Name    string   `json:"name" validate:"required,min=1,max=100"`
+			Type    string   `json:"type" validate:"required,oneof=text number date select"`
+			Options []string `json:"options"`
+		}
+		if err := ctx.ShouldBindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		body.Name = strings.TrimSpace(body.Name)
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+		options := []string{}
+		if body.Type == models.PropertySelect {
+			var ok bool
+			if options, ok = normalizeOptions(ctx, body.Options); !ok {
+				return
+			}
+		} else if len(body.Options) > 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Only select properties have options"})
+			return
+		}
+
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		workspace, role, ok := loadWorkspace(ctx, userID)
+		if !ok {
+			return
+		}
+		if role != models.WorkspaceRoleAdmin {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Only workspace admins can manage properties"})
+			return
+		}
+
+		property := models.WorkspaceProperty{
+			ID:          uuid.New(),
+			WorkspaceID: workspace.ID,
+			Name:        body.Name,
+			Type:        body.Type,
+			Options:     options,
+		}
+		if err := db.Create(&property).Error; err != nil {
+			if isDuplicateKey(err) {
+				ctx.JSON(http.StatusConflict, gin.H{"error": "The workspace already has a property with that name"})
+			} else {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create the property"})
+			}
+			return
+		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.WorkspacePropertyCreated,
+			TargetType: audit.TargetWorkspace,
+			TargetID:   audit.ID(workspace.ID),
+			Metadata:   map[string]interface{}{"property_id": property.ID, "name": property.Name, "type": property.Type},
+		})
+
+		ctx.JSON(http.StatusCreated, property)
+	}
+}
+
+// loadWorkspaceProperty fetches the :propertyId property of a workspace the
+// caller administers.
+func loadWorkspaceProperty(ctx *gin.Context) (*models.Workspace, *models.WorkspaceProperty, bool) {
+	propertyID, err := uuid.Parse(ctx.Param("propertyId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid property ID"})
+		return nil, nil, false
+	}
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		return nil, nil, false
+	}
+	workspace, role, ok := loadWorkspace(ctx, userID)
+	if !ok {
+		return nil, nil, false
+	}
+	if role != models.WorkspaceRoleAdmin {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Only workspace admins can manage properties"})
+		return nil, nil, false
+	}
+
+	var property models.WorkspaceProperty
+	if err := db.First(&property, "id = ? AND workspace_id = ?", propertyID, workspace.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Property not found"})
+		} else {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return nil, nil, false
+	}
+	return workspace, &property, true
+}
+
+// UpdateWorkspaceProperty renames a property or changes the options of a
+// select. Documents set to an option that's gone lose the value. The type
+// can't change; delete the property and make a new one instead.
+func UpdateWorkspaceProperty() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Name    *string  `json:"name" validate:"omitempty,min=1,max=100"`
+			Options []string `json:"options"`
+		}
+		if err := ctx.ShouldBindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if body.Name != nil {
+			*body.Name = strings.TrimSpace(*body.Name)
+			if *body.Name == "" {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Name can't be empty"})
+				return
+			}
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		workspace, property, ok := loadWorkspaceProperty(ctx)
+		if !ok {
+			return
+		}
+		if body.Options != nil {
+			if property.Type != models.PropertySelect {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Only select properties have options"})
+				return
+			}
+			if property.Options, ok = normalizeOptions(ctx, body.Options); !ok {
+				return
+			}
+		}
+		if body.Name != nil {
+			property.Name = *body.Name
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := touchPropertyDocuments(tx, property.ID); err != nil {
+				return err
+			}
+			if body.Options != nil {
+				err := tx.Where("property_id = ? AND value #>> '{}' NOT IN ?", property.ID, property.Options).
+					Delete(&models.DocumentPropertyValue{}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return tx.Select("name", "options", "updated_at").Save(property).Error
+		})
+		if err != nil {
+			if isDuplicateKey(err) {
+				ctx.JSON(http.StatusConflict, gin.H{"error": "The workspace already has a property with that name"})
+			} else {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the property"})
+			}
+			return
+		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.WorkspacePropertyUpdated,
+			TargetType: audit.TargetWorkspace,
+			TargetID:   audit.ID(workspace.ID),
+			Metadata:   map[string]interface{}{"property_id": property.ID, "name": property.Name, "options": property.Options},
+		})
+
+		ctx.JSON(http.StatusOK, property)
+	}
+}
+
+// DeleteWorkspaceProperty removes a property along with its values.
+func DeleteWorkspaceProperty() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		workspace, property, ok := loadWorkspaceProperty(ctx)
+		if !ok {
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := touchPropertyDocuments(tx, property.ID); err != nil {
+				return err
+			}
+			return tx.Delete(property).Error
+		})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete the property"})
+			return
+		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.WorkspacePropertyDeleted,
+			TargetType: audit.TargetWorkspace,
+			TargetID:   audit.ID(workspace.ID),
+			Metadata:   map[string]interface{}{"property_id": property.ID, "name": property.Name},
+		})
+
+		ctx.JSON(http.StatusOK, gin.H{"success": "ok"})
+	}
+}
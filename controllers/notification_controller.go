@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/notify"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GetNotifications lists the user's notifications, newest first, with the
+// number still unread. ?unread=true leaves out the ones already read.
+func GetNotifications() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		page, perPage := pageParams(ctx)
+
+		query := db.Model(&models.Notification{}).Where("user_id = ? AND in_app", userID)
+		if ctx.Query("unread") == "true" {
+			query = query.Where("read_at IS NULL")
+		}
+		var total int64
+		if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the notifications"})
+			return
+		}
+		var notifications []models.Notification
+		err := query.Order("created_at DESC").
+			Offset((page - 1) * perPage).
+			Limit(perPage).
+			Find(&notifications).Error
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the notifications"})
+			return
+		}
+		unread, err := notify.UnreadCount(userID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the notifications"})
+			return
+		}
+
+		actorIDs := make([]uuid.UUID, 0, len(notifications))
+		for _, n := range notifications {
+			if n.ActorID != nil {
+				actorIDs = append(actorIDs, *n.ActorID)
+			}
+		}
+		var actors []models.User
+		if len(actorIDs) > 0 {
+			if err := db.Select("id", "name").Where("id IN ?", actorIDs).Find(&actors).Error; err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the actors"})
+				return
+			}
+		}
+		names := make(map[uuid.UUID]string, len(actors))
+		for _, a := range actors {
+			names[a.ID] = a.Name
+		}
+		for i, n := range notifications {
+			if n.ActorID != nil {
+				notifications[i].Actor = &models.Author{ID: *n.ActorID, Name: names[*n.ActorID]}
+			}
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"notifications": notifications,
+			"unread":        unread,
+			"total":         total,
+			"page":          page,
+			"per_page":      perPage,
+		})
+	}
+}
+
+// MarkNotificationRead marks one notification read.
+func MarkNotificationRead() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		notificationID, err := uuid.Parse(ctx.Param("id"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+			return
+		}
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		var n models.Notification
+		if err := db.First(&n, "id = ? AND user_id = ?", notificationID, userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+			} else {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			}
+			return
+		}
+		if n.ReadAt == nil {
+			if err := db.Model(&n).Update("read_at", time.Now()).Error; err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the notification"})
+				return
+			}
+			notify.PushUnread(userID)
+		}
+		ctx.JSON(http.StatusOK, gin.H{"success": "ok"})
+	}
+}
+
+// MarkNotificationsRead marks the notifications in "ids" read, or all of
+// them without a body.
+func MarkNotificationsRead() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			IDs []uuid.UUID `json:"ids"`
+		}
+		if err := ctx.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		query := db.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID)
+		if body.IDs != nil {
+			query = query.Where("id IN ?", body.IDs)
+		}
+		res := query.Update("read_at", time.Now())
+		if res.Error != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the notifications"})
+			return
+		}
+		if res.RowsAffected > 0 {
+			notify.PushUnread(userID)
+		}
+		ctx.JSON(http.StatusOK, gin.H{"success": "ok", "marked": res.RowsAffected})
+	}
+}
+
+type notificationPreferencesResponse struct {
+	Digest string                              `json:"digest"`
+	Types  map[string]models.ChannelPreference `json:"types"`
+}
+
+// preferencesResponse spells out every type, defaults included.
+func preferencesResponse(prefs *models.NotificationPreferences) notificationPreferencesResponse {
+	types := make(map[string]models.ChannelPreference, len(notify.Types))
+	for _, t := range notify.Types {
+		types[t] = notify.Channel(prefs, t)
+	}
+	return notificationPreferencesResponse{Digest: prefs.Digest, Types: types}
+}
+
+func GetNotificationPreferences() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		prefs, err := notify.Preferences(userID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		ctx.JSON(http.StatusOK, preferencesResponse(prefs))
+	}
+}
+
+// UpdateNotificationPreferences changes the digest frequency and the
+// channels of the types sent; types left out keep their setting.
+func UpdateNotificationPreferences() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Digest *string                             `json:"digest" validate:"omitempty,oneof=off hourly daily"`
+			Types  map[string]models.ChannelPreference `json:"types"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+		for t := range body.Types {
+			if !notify.ValidType(t) {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Unknown notification type " + t, "types": notify.Types})
+				return
+			}
+		}
+
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		prefs, err := notify.Preferences(userID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if body.Digest != nil {
+			prefs.Digest = *body.Digest
+		}
+		for t, channel := range body.Types {
+			prefs.Types[t] = channel
+		}
+		prefs.UpdatedAt = time.Now()
+		if err := db.Save(prefs).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save the preferences"})
+			return
+		}
+		ctx.JSON(http.StatusOK, preferencesResponse(prefs))
+	}
+}
@@ -0,0 +1,374 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dipankarupd/text-editor/audit"
+	database "github.com/dipankarupd/text-editor/db"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/oauth"
+	"github.com/dipankarupd/text-editor/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	oauthStateTTL    = 10 * time.Minute
+	oauthStateCookie = "oauth_state"
+)
+
+// oauthState is what we remember between sending the user to the provider
+// and the provider sending them back.
+type oauthState struct {
+	Provider     string     `json:"provider"`
+	CodeVerifier string     `json:"code_verifier"`
+	Nonce        string     `json:"nonce"`
+	LinkUserID   *uuid.UUID `json:"link_user_id,omitempty"`
+}
+
+func GetOAuthProviders() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{"providers": oauth.ProviderNames()})
+	}
+}
+
+// startOAuth stores the PKCE verifier and nonce under a random state and
+// returns the provider's authorization URL.
+func startOAuth(ctx *gin.Context, linkUserID *uuid.UUID) {
+	provider, ok := oauth.Lookup(ctx.Param("provider"))
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Unknown provider"})
+		return
+	}
+
+	verifier, challenge := oauth.NewPKCE()
+	state := oauth.RandomToken(24)
+	nonce := oauth.RandomToken(24)
+
+	authURL, err := provider.AuthCodeURL(ctx.Request.Context(), state, challenge, nonce)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": "Provider is unavailable"})
+		return
+	}
+
+	payload, _ := json.Marshal(oauthState{
+		Provider:     provider.Name(),
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		LinkUserID:   linkUserID,
+	})
+	if err := database.RedisClient.Set(ctx, "oauth_state:"+state, payload, oauthStateTTL).Err(); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	setOAuthStateCookie(ctx, utils.HashToken(state), int(oauthStateTTL/time.Second))
+
+	ctx.JSON(http.StatusOK, gin.H{"authorization_url": authURL, "state": state})
+}
+
+// setOAuthStateCookie ties the flow to the browser that started it, so nobody
+// can get someone else to finish a flow they started. The frontend is on
+// another site, so over https the cookie has to be SameSite=None.
+func setOAuthStateCookie(ctx *gin.Context, value string, maxAge int) {
+	secure := ctx.Request.TLS != nil || ctx.GetHeader("X-Forwarded-Proto") == "https"
+	sameSite := http.SameSiteLaxMode
+	if secure {
+		sameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    value,
+		Path:     "/auth/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: sameSite,
+	})
+}
+
+// AuthorizeOAuth starts a sign in with an external provider.
+func AuthorizeOAuth() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		startOAuth(ctx, nil)
+	}
+}
+
+// LinkOAuthIdentity starts the same flow for a signed in user; the callback
+// then links the provider account to them instead of signing in.
+func LinkOAuthIdentity() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		startOAuth(ctx, &userID)
+	}
+}
+
+// OAuthCallback finishes the flow with the code and state the provider
+// redirected back with.
+func OAuthCallback() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if errCode := ctx.Query("error"); errCode != "" {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Login was cancelled or denied", "details": errCode})
+			return
+		}
+		code, stateKey := ctx.Query("code"), ctx.Query("state")
+		if code == "" || stateKey == "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "code and state are required"})
+			return
+		}
+
+		cookie, err := ctx.Cookie(oauthStateCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(cookie), []byte(utils.HashToken(stateKey))) != 1 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "This login was started in another browser, please try again"})
+			return
+		}
+		setOAuthStateCookie(ctx, "", -1)
+
+		// states are single use
+		raw, err := database.RedisClient.GetDel(ctx, "oauth_state:"+stateKey).Result()
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Login session expired, please try again"})
+			return
+		}
+		var state oauthState
+		if err := json.Unmarshal([]byte(raw), &state); err != nil || state.Provider != ctx.Param("provider") {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
+			return
+		}
+
+		provider, ok := oauth.Lookup(state.Provider)
+		if !ok {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Unknown provider"})
+			return
+		}
+		identity, err := provider.Exchange(ctx.Request.Context(), code, state.CodeVerifier, state.Nonce)
+		if err != nil {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Could not verify the login with the provider"})
+			return
+		}
+
+		if state.LinkUserID != nil {
+			linkIdentity(ctx, *state.LinkUserID, identity)
+			return
+		}
+		signInWithIdentity(ctx, identity)
+	}
+}
+
+// signInWithIdentity signs in the user linked to the identity, creating an
+// account on first sign in. An email that already belongs to another account
+// is never linked implicitly; the owner has to link it while signed in.
+func signInWithIdentity(ctx *gin.Context, identity *oauth.Identity) {
+	var linked models.UserIdentity
+	err := db.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&linked).Error
+	if err == nil {
+		var user models.User
+		if err := db.First(&user, "id = ?", linked.UserID).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		db.Model(&linked).Update("last_login_at", time.Now())
+		completeLogin(ctx, http.StatusOK, user)
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "The provider did not return a verified email"})
+		return
+	}
+
+	var existingUser models.User
+	err = db.Where("email = ?", identity.Email).First(&existingUser).Error
+	if err == nil {
+		adopt, err := isUnlinkedProviderAccount(existingUser, identity.Provider)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if !adopt {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error":         "An account with this email already exists. Sign in to it and link this provider from your account.",
+				"link_required": true,
+			})
+			return
+		}
+		if err := createIdentity(db, existingUser.ID, identity); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if existingUser.EmailVerifiedAt == nil {
+			now := time.Now()
+			existingUser.EmailVerifiedAt = &now
+			db.Model(&existingUser).Update("email_verified_at", now)
+		}
+		completeLogin(ctx, http.StatusOK, existingUser)
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// user does not exist
+	now := time.Now()
+	name := identity.Name
+	if name == "" {
+		name = strings.Split(identity.Email, "@")[0]
+	}
+	user := models.User{
+		ID:              uuid.New(),
+		Name:            name,
+		Email:           identity.Email,
+		Provider:        identity.Provider,
+		EmailVerifiedAt: &now, // checked above
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return createIdentity(tx, user.ID, identity)
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+	audit.Record(ctx, audit.Event{
+		Action:   audit.UserRegistered,
+		ActorID:  audit.ID(user.ID),
+		Metadata: map[string]interface{}{"provider": user.Provider},
+	})
+
+	if respondWithTokens(ctx, http.StatusCreated, user) {
+		audit.Record(ctx, audit.Event{Action: audit.Login, ActorID: audit.ID(user.ID)})
+	}
+}
+
+// isUnlinkedProviderAccount spots accounts created through a provider before
+// identities were recorded (Google sign ins used to trust the posted email).
+// Such an account has no password and no identity, so binding it to the now
+// verified email is safe.
+func isUnlinkedProviderAccount(user models.User, provider string) (bool, error) {
+	if user.PasswordHash != nil || user.Provider != provider {
+		return false, nil
+	}
+	var count int64
+	if err := db.Model(&models.UserIdentity{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+func linkIdentity(ctx *gin.Context, userID uuid.UUID, identity *oauth.Identity) {
+	var linked models.UserIdentity
+	err := db.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&linked).Error
+	if err == nil {
+		if linked.UserID == userID {
+			ctx.JSON(http.StatusOK, linked)
+		} else {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "This account is already linked to another user"})
+		}
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := createIdentity(db, userID, identity); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link account"})
+		return
+	}
+	if err := db.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&linked).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	ctx.JSON(http.StatusCreated, linked)
+}
+
+func createIdentity(tx *gorm.DB, userID uuid.UUID, identity *oauth.Identity) error {
+	now := time.Now()
+	record := models.UserIdentity{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Provider:    identity.Provider,
+		Subject:     identity.Subject,
+		CreatedAt:   now,
+		LastLoginAt: &now,
+	}
+	if identity.Email != "" {
+		record.Email = &identity.Email
+	}
+	return tx.Create(&record).Error
+}
+
+func GetUserIdentities() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		var identities []models.UserIdentity
+		if err := db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the identities"})
+			return
+		}
+		ctx.JSON(http.StatusOK, identities)
+	}
+}
+
+// UnlinkUserIdentity removes a linked provider as long as the user keeps
+// another way to sign in.
+func UnlinkUserIdentity() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		identityID, err := uuid.Parse(ctx.Param("id"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid identity ID"})
+			return
+		}
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
+			return
+		}
+		var count int64
+		if err := db.Model(&models.UserIdentity{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if user.PasswordHash == nil && count <= 1 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "You can't remove your only way to sign in"})
+			return
+		}
+
+		res := db.Where("id = ? AND user_id = ?", identityID, userID).Delete(&models.UserIdentity{})
+		if res.Error != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink"})
+			return
+		}
+		if res.RowsAffected == 0 {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Identity not found"})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{"success": "ok"})
+	}
+}
@@ -0,0 +1,458 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dipankarupd/text-editor/audit"
+	"github.com/dipankarupd/text-editor/mailer"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/utils"
+	"github.com/dipankarupd/text-editor/webhooks"
+	"github.com/dipankarupd/text-editor/ws"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const changeEmailTTL = 24 * time.Hour
+
+// loadCurrentUser fetches the signed in user, writing the error response
+// itself when it can't.
+func loadCurrentUser(ctx *gin.Context) (*models.User, bool) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		return nil, false
+	}
+	var user models.User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// confirmPassword checks the password of an account that has one, writing
+// the error response itself when it's wrong.
+func confirmPassword(ctx *gin.Context, user *models.User, password string) bool {
+	if user.PasswordHash == nil {
+		return true
+	}
+	if password == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "password is required"})
+		return false
+	}
+	// shares the login lockout, so a stolen session can't guess the password
+	if !checkLoginLock(ctx, user.Email) {
+		return false
+	}
+	if valid, _ := utils.CheckHash(password, *user.PasswordHash); !valid {
+		if _, err := utils.RecordLoginFailure(ctx, user.Email); err != nil {
+			log.Printf("failed to record login failure: %v", err)
+		}
+		recordLoginFailure(ctx, user.Email, user.ID, "confirm_password")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "incorrect password"})
+		return false
+	}
+	return true
+}
+
+// UpdateProfile changes the signed in user's name and avatar.
+func UpdateProfile() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Name      *string `json:"name" validate:"omitempty,min=2,max=30"`
+			AvatarURL *string `json:"avatar_url" validate:"omitempty,url,max=2048"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		user, ok := loadCurrentUser(ctx)
+		if !ok {
+			return
+		}
+
+		updates := map[string]interface{}{}
+		if body.Name != nil {
+			updates["name"] = strings.TrimSpace(*body.Name)
+		}
+		if body.AvatarURL != nil {
+			// an empty string clears the avatar
+			if *body.AvatarURL == "" {
+				updates["avatar_url"] = nil
+			} else if !strings.HasPrefix(*body.AvatarURL, "https://") && !strings.HasPrefix(*body.AvatarURL, "http://") {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "avatar_url must be an http(s) URL"})
+				return
+			} else {
+				updates["avatar_url"] = *body.AvatarURL
+			}
+		}
+		if len(updates) == 0 {
+			ctx.JSON(http.StatusOK, user)
+			return
+		}
+		updates["updated_at"] = time.Now()
+
+		if err := db.Model(user).Updates(updates).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+			return
+		}
+		if err := db.First(user, "id = ?", user.ID).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		ctx.JSON(http.StatusOK, user)
+	}
+}
+
+// ChangePassword sets a new password after checking the current one. Every
+// other device is signed out and this one gets a fresh session.
+func ChangePassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			CurrentPassword string `json:"current_password"`
+			NewPassword     string `json:"new_password" validate:"required,min=6"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		user, ok := loadCurrentUser(ctx)
+		if !ok {
+			return
+		}
+		// accounts that only sign in through a provider can set a first password
+		if !confirmPassword(ctx, user, body.CurrentPassword) {
+			return
+		}
+
+		hashedPassword := utils.PerformHash(body.NewPassword)
+		if err := db.Model(user).Updates(map[string]interface{}{
+			"password_hash": hashedPassword,
+			"updated_at":    time.Now(),
+		}).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+			return
+		}
+
+		if err := revokeAllTokens(ctx, user.ID); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Password was changed but signing out other devices failed"})
+			return
+		}
+		mailer.SendAsync(mailer.Message{
+			To:      user.Email,
+			Subject: "Your password was changed",
+			Body:    fmt.Sprintf("Hi %s,\n\nThe password for your account was just changed and your other devices were signed out. If it wasn't you, reset your password right away.\n", user.Name),
+		})
+
+		audit.Record(ctx, audit.Event{Action: audit.PasswordChanged, TargetType: audit.TargetUser, TargetID: audit.ID(user.ID)})
+
+		user.PasswordHash = &hashedPassword
+		respondWithTokens(ctx, http.StatusOK, *user)
+	}
+}
+
+// RequestEmailChange sends a confirmation link to the new address. The email
+// only changes once that link is opened.
+func RequestEmailChange() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Email    string `json:"email" validate:"required,email"`
+			Password string `json:"password"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		user, ok := loadCurrentUser(ctx)
+		if !ok {
+			return
+		}
+		if !confirmPassword(ctx, user, body.Password) {
+			return
+		}
+		if strings.EqualFold(body.Email, user.Email) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "That is already your email"})
+			return
+		}
+
+		var count int64
+		if err := db.Model(&models.User{}).Where("email = ?", body.Email).Count(&count).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if count > 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "email already exists"})
+			return
+		}
+
+		var raw string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := expireUserTokens(tx, user.ID, models.TokenChangeEmail); err != nil {
+				return err
+			}
+			var err error
+			raw, err = issueUserToken(tx, user.ID, models.TokenChangeEmail, changeEmailTTL, body.Email)
+			return err
+		})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		mailer.SendAsync(mailer.Message{
+			To:      body.Email,
+			Subject: "Confirm your new email",
+			Body: fmt.Sprintf("Hi %s,\n\nTo use this address for your account, open this link:\n\n%s\n\nThe link is valid for 24 hours.\n",
+				user.Name, appLink("/confirm-email", raw)),
+		})
+		mailer.SendAsync(mailer.Message{
+			To:      user.Email,
+			Subject: "Email change requested",
+			Body:    fmt.Sprintf("Hi %s,\n\nSomeone asked to change the email of your account to %s. Nothing changes until the new address is confirmed. If it wasn't you, change your password.\n", user.Name, body.Email),
+		})
+		ctx.JSON(http.StatusAccepted, gin.H{"success": "confirmation sent to the new email"})
+	}
+}
+
+// ConfirmEmailChange switches the account to the new address with the token
+// emailed there.
+func ConfirmEmailChange() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Token string `json:"token" validate:"required"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		var user models.User
+		var oldEmail string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			token, err := consumeUserToken(tx, body.Token, models.TokenChangeEmail)
+			if err != nil {
+				return err
+			}
+			if token.Data == nil {
+				return errInvalidUserToken
+			}
+			if err := tx.Model(&models.User{}).Where("id = ?", token.UserID).Pluck("email", &oldEmail).Error; err != nil {
+				return err
+			}
+
+			now := time.Now()
+			if err := tx.Model(&models.User{}).Where("id = ?", token.UserID).Updates(map[string]interface{}{
+				"email":             *token.Data,
+				"email_verified_at": now,
+				"updated_at":        now,
+			}).Error; err != nil {
+				return err
+			}
+			return tx.First(&user, "id = ?", token.UserID).Error
+		})
+		if errors.Is(err, errInvalidUserToken) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "This link is invalid or has expired"})
+			return
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) || (err != nil && strings.Contains(err.Error(), "duplicate key")) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "email already exists"})
+			return
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+			return
+		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.EmailChanged,
+			ActorID:    audit.ID(user.ID),
+			TargetType: audit.TargetUser,
+			TargetID:   audit.ID(user.ID),
+			Metadata:   map[string]interface{}{"from": oldEmail, "to": user.Email},
+		})
+		ctx.JSON(http.StatusOK, user)
+	}
+}
+
+// DeleteAccount removes the signed in user. What happens to their documents
+// must be chosen explicitly: "delete" them or "transfer" them to another user.
+// Workspaces they own pass to another admin; the ones nobody else is in are
+// deleted along with the account.
+func DeleteAccount() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Password   string `json:"password"`
+			Confirm    string `json:"confirm"` // the account email, for accounts without a password
+			Documents  string `json:"documents" validate:"required,oneof=delete transfer"`
+			TransferTo string `json:"transfer_to" validate:"required_if=Documents transfer,omitempty,email"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		user, ok := loadCurrentUser(ctx)
+		if !ok {
+			return
+		}
+		if user.PasswordHash == nil && !strings.EqualFold(body.Confirm, user.Email) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Type your email in confirm to delete the account"})
+			return
+		}
+		if !confirmPassword(ctx, user, body.Password) {
+			return
+		}
+
+		var recipient models.User
+		if body.Documents == "transfer" {
+			err := db.Where("email = ?", body.TransferTo).First(&recipient).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "No user with the transfer_to email"})
+				return
+			}
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			if recipient.ID == user.ID {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Can't transfer documents to yourself"})
+				return
+			}
+		}
+
+		// a workspace with other people in it needs another admin to take over
+		var orphaned []models.Workspace
+		err := db.Raw(`
+			SELECT w.* FROM workspaces w
+			WHERE w.owner_id = ?
+			  AND EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = w.id AND m.user_id <> ?)
+			  AND NOT EXISTS (SELECT 1 FROM workspace_members m WHERE m.workspace_id = w.id AND m.user_id <> ? AND m.role = ?)
+		`, user.ID, user.ID, user.ID, models.WorkspaceRoleAdmin).Scan(&orphaned).Error
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if len(orphaned) > 0 {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error":      "Make another member an admin of these workspaces, or remove their members, before deleting your account",
+				"workspaces": orphaned,
+			})
+			return
+		}
+
+		var sessionIDs []uuid.UUID
+		if err := db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Pluck("id", &sessionIDs).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		var transferred int64
+		var deleted []models.Document
+		err = db.Transaction(func(tx *gorm.DB) error {
+			// hand owned workspaces to the longest standing other admin
+			if err := tx.Exec(`
+				UPDATE workspaces w SET owner_id = (
+					SELECT m.user_id FROM workspace_members m
+					WHERE m.workspace_id = w.id AND m.user_id <> ? AND m.role = ?
+					ORDER BY m.created_at LIMIT 1
+				), updated_at = now()
+				WHERE w.owner_id = ? AND EXISTS (
+					SELECT 1 FROM workspace_members m
+					WHERE m.workspace_id = w.id AND m.user_id <> ? AND m.role = ?
+				)
+			`, user.ID, models.WorkspaceRoleAdmin, user.ID, user.ID, models.WorkspaceRoleAdmin).Error; err != nil {
+				return err
+			}
+			// shared templates stay with the workspace
+			if err := tx.Exec(`
+				UPDATE templates t SET owner_id = w.owner_id
+				FROM workspaces w
+				WHERE t.workspace_id = w.id AND t.owner_id = ? AND w.owner_id <> ?
+			`, user.ID, user.ID).Error; err != nil {
+				return err
+			}
+
+			if body.Documents == "transfer" {
+				res := tx.Model(&models.Document{}).Where("author_id = ?", user.ID).Updates(map[string]interface{}{
+					"author_id":  recipient.ID,
+					"updated_at": time.Now(),
+				})
+				if res.Error != nil {
+					return res.Error
+				}
+				transferred = res.RowsAffected
+			} else {
+				// kept for the audit log, and for the webhooks on workspaces
+				// which outlive the document; its own webhooks go with it
+				if err := tx.Omit("content").Where("author_id = ?", user.ID).Find(&deleted).Error; err != nil {
+					return err
+				}
+			}
+
+			// documents, personal templates, workspaces nobody else is in,
+			// memberships, identities, sessions and tokens go with the user
+			return tx.Delete(&models.User{}, "id = ?", user.ID).Error
+		})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+			return
+		}
+
+		if err := utils.SetTokensValidAfter(ctx, user.ID, time.Now()); err != nil {
+			log.Printf("Failed to revoke tokens of deleted user %s: %v", user.ID, err)
+		}
+		if err := utils.RevokeRefreshTokens(ctx, sessionIDs...); err != nil {
+			log.Printf("Failed to revoke refresh tokens of deleted user %s: %v", user.ID, err)
+		}
+		ws.DisconnectUser(user.ID)
+		for i := range deleted {
+			doc := &deleted[i]
+			audit.Record(ctx, audit.Event{
+				Action:     audit.DocumentDeleted,
+				TargetType: audit.TargetDocument,
+				TargetID:   audit.ID(doc.ID),
+				Metadata:   map[string]interface{}{"title": doc.Title, "workspace_id": doc.WorkspaceID, "reason": "author_deleted"},
+			})
+			if doc.WorkspaceID != nil {
+				webhooks.Dispatch(webhooks.DocumentDeleted, doc, map[string]interface{}{"reason": "author_deleted"})
+			}
+		}
+
+		audit.Record(ctx, audit.Event{
+			Action:     audit.UserDeleted,
+			TargetType: audit.TargetUser,
+			TargetID:   audit.ID(user.ID),
+			Metadata:   map[string]interface{}{"email": user.Email, "documents": body.Documents, "documents_transferred": transferred},
+		})
+		ctx.JSON(http.StatusOK, gin.H{"success": "account deleted", "documents_transferred": transferred})
+	}
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dipankarupd/text-editor/audit"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/utils"
+	"github.com/dipankarupd/text-editor/ws"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// startSession records a new signed in device for the user.
+func startSession(ctx *gin.Context, userID uuid.UUID) (models.Session, error) {
+	now := time.Now()
+	session := models.Session{
+		ID:         uuid.New(),
+		UserID:     userID,
+		UserAgent:  ctx.Request.UserAgent(),
+		IPAddress:  ctx.ClientIP(),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(utils.RefreshTokenTTL),
+	}
+	return session, db.Create(&session).Error
+}
+
+// touchSession notes that the session was just refreshed from this device.
+func touchSession(ctx *gin.Context, sessionID uuid.UUID) {
+	now := time.Now()
+	db.Model(&models.Session{}).Where("id = ?", sessionID).Updates(map[string]interface{}{
+		"last_used_at": now,
+		"expires_at":   now.Add(utils.RefreshTokenTTL),
+		"user_agent":   ctx.Request.UserAgent(),
+		"ip_address":   ctx.ClientIP(),
+	})
+}
+
+func currentSessionID(ctx *gin.Context) uuid.UUID {
+	sessionID, _ := ctx.Get("sessionid")
+	id, _ := sessionID.(uuid.UUID)
+	return id
+}
+
+// revokeSessions ends the sessions matching the query for the user: their
+// refresh and access tokens stop working and their websockets are closed.
+func revokeSessions(ctx *gin.Context, userID uuid.UUID, query string, args ...interface{}) (int, error) {
+	var ids []uuid.UUID
+	err := db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Where(query, args...).
+		Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	if err := db.Model(&models.Session{}).Where("id IN ?", ids).Update("revoked_at", time.Now()).Error; err != nil {
+		return 0, err
+	}
+	if err := utils.RevokeRefreshTokens(ctx, ids...); err != nil {
+		return 0, err
+	}
+	if err := utils.RevokeSessionAccess(ctx, ids...); err != nil {
+		return 0, err
+	}
+	ws.DisconnectSessions(ids...)
+	return len(ids), nil
+}
+
+// revokeAllTokens signs the user out everywhere, including tokens that don't
+// belong to a session. Used for logout-all and password changes.
+func revokeAllTokens(ctx *gin.Context, userID uuid.UUID) error {
+	if err := utils.SetTokensValidAfter(ctx, userID, time.Now()); err != nil {
+		return err
+	}
+	if _, err := revokeSessions(ctx, userID, "1 = 1"); err != nil {
+		return err
+	}
+	ws.DisconnectUser(userID)
+	return nil
+}
+
+// LogoutAll signs the user out on every device, this one included.
+func LogoutAll() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		if err := revokeAllTokens(ctx, userID); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to logout"})
+			return
+		}
+		audit.Record(ctx, audit.Event{Action: audit.LogoutAll})
+		ctx.JSON(http.StatusOK, gin.H{"success": "logout success"})
+	}
+}
+
+// revokeReusedSession ends a session whose old refresh token came back after
+// it was rotated. Either the client or whoever copied the token is an
+// attacker, and we can't tell which, so both get signed out.
+func revokeReusedSession(ctx *gin.Context, sessionID uuid.UUID) {
+	var session models.Session
+	if err := db.First(&session, "id = ?", sessionID).Error; err != nil {
+		log.Printf("security: refresh token reuse for unknown session %s from %s", sessionID, ctx.ClientIP())
+		return
+	}
+	log.Printf("security: refresh token reuse for session %s of user %s from %s (%s), revoking the session",
+		sessionID, session.UserID, ctx.ClientIP(), ctx.Request.UserAgent())
+
+	if _, err := revokeSessions(ctx, session.UserID, "id = ?", sessionID); err != nil {
+		log.Printf("failed to revoke session %s: %v", sessionID, err)
+	}
+	audit.Record(ctx, audit.Event{
+		Action:     audit.SessionRevoked,
+		ActorID:    audit.ID(session.UserID),
+		TargetType: audit.TargetSession,
+		TargetID:   audit.ID(sessionID),
+		Metadata:   map[string]interface{}{"reason": "refresh_token_reused"},
+	})
+}
+
+// GetSessions lists the devices the user is signed in on.
+func GetSessions() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		var sessions []models.Session
+		err := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+			Order("last_used_at DESC").
+			Find(&sessions).Error
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the sessions"})
+			return
+		}
+
+		current := currentSessionID(ctx)
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == current
+		}
+		ctx.JSON(http.StatusOK, sessions)
+	}
+}
+
+// RevokeSession signs one of the user's devices out.
+func RevokeSession() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		sessionID, err := uuid.Parse(ctx.Param("id"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+			return
+		}
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		revoked, err := revokeSessions(ctx, userID, "id = ?", sessionID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke the session"})
+			return
+		}
+		if revoked == 0 {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		audit.Record(ctx, audit.Event{Action: audit.SessionRevoked, TargetType: audit.TargetSession, TargetID: audit.ID(sessionID)})
+		ctx.JSON(http.StatusOK, gin.H{"success": "ok"})
+	}
+}
+
+// RevokeOtherSessions signs out every device except the one making the call.
+func RevokeOtherSessions() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		revoked, err := revokeSessions(ctx, userID, "id <> ?", currentSessionID(ctx))
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke the sessions"})
+			return
+		}
+		if revoked > 0 {
+			audit.Record(ctx, audit.Event{Action: audit.SessionRevoked, Metadata: map[string]interface{}{"others": revoked}})
+		}
+		ctx.JSON(http.StatusOK, gin.H{"revoked": revoked})
+	}
+}
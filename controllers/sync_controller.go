@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dipankarupd/text-editor/access"
+	"github.com/dipankarupd/text-editor/ot"
+	"github.com/dipankarupd/text-editor/ws"
+	"github.com/gin-gonic/gin"
+)
+
+// SyncDocument accepts a batch of changes made offline against base_revision,
+// rebases them onto the current document and broadcasts them to the live room.
+func SyncDocument() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			BaseRevision *int64            `json:"base_revision"`
+			Ops          []json.RawMessage `json:"ops"`
+		}
+		if err := ctx.ShouldBindJSON(&body); err != nil || body.BaseRevision == nil || *body.BaseRevision < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "base_revision and ops are required"})
+			return
+		}
+		if len(body.Ops) == 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "No ops to sync"})
+			return
+		}
+
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		doc, ok := loadDocument(ctx, userID, access.Write)
+		if !ok {
+			return
+		}
+		docID := doc.ID
+
+		// an op that can't be parsed conflicts on its own; everything after it
+		// was made on top of it and gets skipped
+		changes := make([]ot.Delta, 0, len(body.Ops))
+		var invalid *ws.ChangeResult
+		for i, raw := range body.Ops {
+			change, err := ot.Parse(raw)
+			if err != nil {
+				invalid = &ws.ChangeResult{Index: i, Status: ws.ChangeConflict, Error: "invalid delta"}
+				break
+			}
+			changes = append(changes, change)
+		}
+
+		results, updated, err := ws.ApplyChanges(docID, &userID, *body.BaseRevision, changes, ws.SourceSync)
+		if err != nil {
+			switch {
+			case errors.Is(err, ws.ErrRevisionAhead):
+				ctx.JSON(http.StatusConflict, gin.H{"error": "base_revision is newer than the document"})
+			case errors.Is(err, ws.ErrInvalidDocument):
+				ctx.JSON(http.StatusConflict, gin.H{"error": "Document content can't be merged"})
+			default:
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync document"})
+			}
+			return
+		}
+
+		if invalid != nil {
+			for _, r := range results {
+				if r.Status == ws.ChangeConflict {
+					invalid.Status = ws.ChangeSkipped
+					invalid.Error = "depends on a conflicting change"
+				}
+			}
+			results = append(results, *invalid)
+			for i := invalid.Index + 1; i < len(body.Ops); i++ {
+				results = append(results, ws.ChangeResult{Index: i, Status: ws.ChangeSkipped, Error: "depends on a conflicting change"})
+			}
+		}
+
+		ws.BroadcastChanges(nil, docID.String(), results)
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"revision": updated.Revision,
+			"content":  updated.Content,
+			"results":  results,
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dipankarupd/text-editor/access"
+	"github.com/dipankarupd/text-editor/audit"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SaveAsTemplate stores a copy of the document's title and content as a template.
+func SaveAsTemplate() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Name        string     `json:"name"`
+			WorkspaceID *uuid.UUID `json:"workspace_id"`
+		}
+		if err := ctx.ShouldBindJSON(&body); err != nil || body.Name == "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+			return
+		}
+
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		doc, ok := loadDocument(ctx, userID, access.Read)
+		if !ok {
+			return
+		}
+
+		// workspace templates can be added by anyone who can add documents there
+		if body.WorkspaceID != nil {
+			role, err := access.WorkspaceRole(*body.WorkspaceID, userID)
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			if role != models.WorkspaceRoleAdmin && role != models.WorkspaceRoleMember {
+				ctx.JSON(http.StatusForbidden, gin.H{"error": "You can't add templates to this workspace"})
+				return
+			}
+		}
+
+		template := models.Template{
+			ID:          uuid.New(),
+			OwnerID:     userID,
+			WorkspaceID: body.WorkspaceID,
+			Name:        body.Name,
+			Title:       doc.Title,
+			Content:     doc.Content,
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		}
+		if err := db.Create(&template).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create template"})
+			return
+		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.TemplateCreated,
+			TargetType: audit.TargetTemplate,
+			TargetID:   audit.ID(template.ID),
+			Metadata:   map[string]interface{}{"document_id": doc.ID, "workspace_id": template.WorkspaceID},
+		})
+
+		ctx.JSON(http.StatusCreated, template)
+	}
+}
+
+func GetTemplates() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		workspaceIDs, err := access.WorkspaceIDs(userID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the templates"})
+			return
+		}
+
+		// personal templates plus those shared in the user's workspaces
+		query := db.Where("owner_id = ? AND workspace_id IS NULL", userID)
+		if len(workspaceIDs) > 0 {
+			query = query.Or("workspace_id IN ?", workspaceIDs)
+		}
+		var templates []models.Template
+		if err := query.Order("name").Find(&templates).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the templates"})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, templates)
+	}
+}
+
+func DeleteTemplate() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		templateID, err := uuid.Parse(ctx.Param("id"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+			return
+		}
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		var template models.Template
+		if err := db.First(&template, "id = ?", templateID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			} else {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			}
+			return
+		}
+
+		// workspace admins can clean up templates other members added
+		allowed := template.OwnerID == userID
+		if !allowed && template.WorkspaceID != nil {
+			role, err := access.WorkspaceRole(*template.WorkspaceID, userID)
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			allowed = role == models.WorkspaceRoleAdmin
+		}
+		if !allowed {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "You can't delete this template"})
+			return
+		}
+
+		if err := db.Delete(&template).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete template"})
+			return
+		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.TemplateDeleted,
+			TargetType: audit.TargetTemplate,
+			TargetID:   audit.ID(template.ID),
+			Metadata:   map[string]interface{}{"name": template.Name, "owner_id": template.OwnerID},
+		})
+
+		ctx.JSON(http.StatusOK, gin.H{"success": "ok"})
+	}
+}
+
+// findTemplate loads a template the user is allowed to create documents from:
+// their own, or one shared in a workspace they belong to.
+func findTemplate(templateID uuid.UUID, userID uuid.UUID) (*models.Template, error) {
+	var template models.Template
+	if err := db.First(&template, "id = ?", templateID).Error; err != nil {
+		return nil, err
+	}
+	if template.OwnerID == userID && template.WorkspaceID == nil {
+		return &template, nil
+	}
+	if template.WorkspaceID != nil {
+		role, err := access.WorkspaceRole(*template.WorkspaceID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if role != "" {
+			return &template, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
@@ -0,0 +1,461 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dipankarupd/text-editor/audit"
+	database "github.com/dipankarupd/text-editor/db"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/totp"
+	"github.com/dipankarupd/text-editor/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	loginChallengeTTL      = 5 * time.Minute
+	loginChallengeAttempts = 5
+	recoveryCodeCount      = 10
+)
+
+// completeLogin finishes a sign in whose first factor checked out. Accounts
+// with two factor authentication get a challenge token instead of tokens.
+func completeLogin(ctx *gin.Context, status int, user models.User) {
+	if accountDisabled(ctx, user) {
+		return
+	}
+	if user.TOTPEnabledAt == nil {
+		if respondWithTokens(ctx, status, user) {
+			audit.Record(ctx, audit.Event{Action: audit.Login, ActorID: audit.ID(user.ID)})
+		}
+		return
+	}
+
+	raw := utils.RandomToken(32)
+	key := "login_challenge:" + utils.HashToken(raw)
+	pipe := database.RedisClient.TxPipeline()
+	pipe.HSet(ctx, key, "user_id", user.ID.String(), "attempts", 0)
+	pipe.Expire(ctx, key, loginChallengeTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"two_factor_required": true,
+		"challenge_token":     raw,
+		"expires_in":          int(loginChallengeTTL.Seconds()),
+	})
+}
+
+// CompleteTwoFactorLogin trades a challenge token and a TOTP or recovery
+// code for the access and refresh tokens.
+func CompleteTwoFactorLogin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			ChallengeToken string `json:"challenge_token" validate:"required"`
+			Code           string `json:"code" validate:"required_without=RecoveryCode"`
+			RecoveryCode   string `json:"recovery_code"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		key := "login_challenge:" + utils.HashToken(body.ChallengeToken)
+		attempts, err := database.RedisClient.HIncrBy(ctx, key, "attempts", 1).Result()
+		userIDRaw, getErr := database.RedisClient.HGet(ctx, key, "user_id").Result()
+		if err != nil || getErr != nil {
+			// HIncrBy created the key if it had expired; don't leave it around
+			database.RedisClient.Del(ctx, key)
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, please sign in again"})
+			return
+		}
+		if attempts > loginChallengeAttempts {
+			database.RedisClient.Del(ctx, key)
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Too many attempts, please sign in again"})
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, "id = ?", userIDRaw).Error; err != nil {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, please sign in again"})
+			return
+		}
+		if !checkLoginLock(ctx, user.Email) {
+			return
+		}
+
+		ok, err := verifySecondFactor(&user, body.Code, body.RecoveryCode)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the code"})
+			return
+		}
+		if !ok {
+			// wrong codes count towards the same lockout as wrong passwords
+			if _, err := utils.RecordLoginFailure(ctx, user.Email); err != nil {
+				log.Printf("failed to record login failure: %v", err)
+			}
+			recordLoginFailure(ctx, user.Email, user.ID, "two_factor")
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+		utils.ResetLoginFailures(ctx, user.Email)
+
+		// a challenge is good for one sign in
+		if n, err := database.RedisClient.Del(ctx, key).Result(); err != nil || n == 0 {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, please sign in again"})
+			return
+		}
+		if respondWithTokens(ctx, http.StatusOK, user) {
+			audit.Record(ctx, audit.Event{
+				Action:   audit.Login,
+				ActorID:  audit.ID(user.ID),
+				Metadata: map[string]interface{}{"two_factor": true},
+			})
+		}
+	}
+}
+
+// verifySecondFactor checks a TOTP code, or failing that a recovery code.
+// Each TOTP step and each recovery code is accepted only once.
+func verifySecondFactor(user *models.User, code, recoveryCode string) (bool, error) {
+	if user.TOTPEnabledAt == nil || user.TOTPSecret == nil {
+		return false, nil
+	}
+
+	if code != "" {
+		secret, err := utils.Decrypt(*user.TOTPSecret)
+		if err != nil {
+			return false, err
+		}
+		step, ok := totp.Validate(secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		res := db.Model(&models.User{}).
+			Where("id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)", user.ID, step).
+			Update("totp_last_step", step)
+		return res.RowsAffected == 1, res.Error
+	}
+
+	if recoveryCode != "" {
+		res := db.Model(&models.UserRecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, utils.HashToken(normalizeRecoveryCode(recoveryCode))).
+			Update("used_at", time.Now())
+		return res.RowsAffected == 1, res.Error
+	}
+	return false, nil
+}
+
+// confirmSecondFactor is verifySecondFactor for authenticated endpoints,
+// writing the error response itself.
+func confirmSecondFactor(ctx *gin.Context, user *models.User, code, recoveryCode string) bool {
+	ok, err := verifySecondFactor(user, code, recoveryCode)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the code"})
+		return false
+	}
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return false
+	}
+	return true
+}
+
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// randomRecoveryChars draws n characters from recoveryAlphabet. 256 isn't a
+// multiple of its length, so bytes past the last whole multiple are thrown
+// away; otherwise the first few characters would come up more often.
+func randomRecoveryChars(n int) ([]byte, error) {
+	limit := 256 - 256%len(recoveryAlphabet)
+	out := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(out) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		for _, c := range buf {
+			if int(c) < limit && len(out) < n {
+				out = append(out, recoveryAlphabet[int(c)%len(recoveryAlphabet)])
+			}
+		}
+	}
+	return out, nil
+}
+
+// replaceRecoveryCodes drops the user's old recovery codes and returns a new
+// set in the clear, to be shown once.
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.UserRecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]models.UserRecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		b, err := randomRecoveryChars(10)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		rows[i] = models.UserRecoveryCode{
+			ID:       uuid.New(),
+			UserID:   userID,
+			CodeHash: utils.HashToken(normalizeRecoveryCode(codes[i])),
+		}
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// GetTwoFactorStatus says whether 2FA is on and how many recovery codes are left.
+func GetTwoFactorStatus() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user, ok := loadCurrentUser(ctx)
+		if !ok {
+			return
+		}
+		var remaining int64
+		if err := db.Model(&models.UserRecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&remaining).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"enabled":                  user.TOTPEnabledAt != nil,
+			"enabled_at":               user.TOTPEnabledAt,
+			"recovery_codes_remaining": remaining,
+		})
+	}
+}
+
+// EnrollTOTP creates a new secret for the user. It isn't used for sign in
+// until a code from it is confirmed with VerifyTOTP.
+func EnrollTOTP() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Password string `json:"password"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+
+		user, ok := loadCurrentUser(ctx)
+		if !ok {
+			return
+		}
+		if user.TOTPEnabledAt != nil {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "Two factor authentication is already on"})
+			return
+		}
+		if !confirmPassword(ctx, user, body.Password) {
+			return
+		}
+
+		secret, err := totp.NewSecret()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create secret"})
+			return
+		}
+		encrypted, err := utils.Encrypt(secret)
+		if errors.Is(err, utils.ErrNoEncryptionKey) {
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "Two factor authentication is not configured"})
+			return
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create secret"})
+			return
+		}
+		if err := db.Model(user).Updates(map[string]interface{}{
+			"totp_secret":    encrypted,
+			"totp_last_step": nil,
+		}).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		issuer := os.Getenv("TOTP_ISSUER")
+		if issuer == "" {
+			issuer = "Collaborative Editor"
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"secret":      secret,
+			"otpauth_uri": totp.URI(issuer, user.Email, secret),
+		})
+	}
+}
+
+// VerifyTOTP turns 2FA on once the user proves their app has the secret,
+// returns their recovery codes and signs out their other devices.
+func VerifyTOTP() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Code string `json:"code" validate:"required"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		user, ok := loadCurrentUser(ctx)
+		if !ok {
+			return
+		}
+		if user.TOTPEnabledAt != nil {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "Two factor authentication is already on"})
+			return
+		}
+		if user.TOTPSecret == nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Start enrollment first"})
+			return
+		}
+
+		secret, err := utils.Decrypt(*user.TOTPSecret)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the code"})
+			return
+		}
+		step, valid := totp.Validate(secret, body.Code, time.Now())
+		if !valid {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+
+		var codes []string
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(user).Updates(map[string]interface{}{
+				"totp_enabled_at": time.Now(),
+				"totp_last_step":  step,
+			}).Error; err != nil {
+				return err
+			}
+			codes, err = replaceRecoveryCodes(tx, user.ID)
+			return err
+		})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to turn on two factor authentication"})
+			return
+		}
+
+		if _, err := revokeSessions(ctx, user.ID, "id <> ?", currentSessionID(ctx)); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Two factor authentication is on but signing out other devices failed", "recovery_codes": codes})
+			return
+		}
+		audit.Record(ctx, audit.Event{Action: audit.TwoFactorEnabled, TargetType: audit.TargetUser, TargetID: audit.ID(user.ID)})
+		ctx.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	}
+}
+
+// DisableTOTP turns 2FA off. It takes the password and a current code, so a
+// stolen session alone can't remove it.
+func DisableTOTP() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Password     string `json:"password"`
+			Code         string `json:"code" validate:"required_without=RecoveryCode"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		user, ok := loadCurrentUser(ctx)
+		if !ok {
+			return
+		}
+		if user.TOTPEnabledAt == nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Two factor authentication is not on"})
+			return
+		}
+		if !confirmPassword(ctx, user, body.Password) || !confirmSecondFactor(ctx, user, body.Code, body.RecoveryCode) {
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(user).Updates(map[string]interface{}{
+				"totp_secret":     nil,
+				"totp_enabled_at": nil,
+				"totp_last_step":  nil,
+			}).Error; err != nil {
+				return err
+			}
+			return tx.Where("user_id = ?", user.ID).Delete(&models.UserRecoveryCode{}).Error
+		})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to turn off two factor authentication"})
+			return
+		}
+		audit.Record(ctx, audit.Event{Action: audit.TwoFactorDisabled, TargetType: audit.TargetUser, TargetID: audit.ID(user.ID)})
+		ctx.JSON(http.StatusOK, gin.H{"success": "two factor authentication turned off"})
+	}
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes; the old ones stop working.
+func RegenerateRecoveryCodes() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Code string `json:"code" validate:"required"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		user, ok := loadCurrentUser(ctx)
+		if !ok {
+			return
+		}
+		if user.TOTPEnabledAt == nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Two factor authentication is not on"})
+			return
+		}
+		if !confirmSecondFactor(ctx, user, body.Code, "") {
+			return
+		}
+
+		var codes []string
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			codes, err = replaceRecoveryCodes(tx, user.ID)
+			return err
+		})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recovery codes"})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	}
+}
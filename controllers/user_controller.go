@@ -3,12 +3,14 @@ package controllers
 import (
 	"context"
 	"errors"
-	"fmt"
+	"log"
 	"net/http"
 	"time"
 
-	database "github.com/dipankarupd/text-editor/db"
+	"github.com/dipankarupd/text-editor/audit"
+	"github.com/dipankarupd/text-editor/middlewares"
 	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/oauth"
 	"github.com/dipankarupd/text-editor/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
@@ -76,61 +78,45 @@ func RegisterUser() gin.HandlerFunc {
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 			return
 		}
+		audit.Record(ctx, audit.Event{
+			Action:   audit.UserRegistered,
+			ActorID:  audit.ID(user.ID),
+			Metadata: map[string]interface{}{"provider": user.Provider},
+		})
 
-		// create the access and refresh token
-		accessToken, refreshToken, err := utils.GenerateAccessAndRefreshToken(user.ID, user.Name, user.Email)
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating tokens"})
-			return
+		if err := sendVerificationEmail(user); err != nil {
+			log.Printf("Failed to send verification email to %s: %v", user.Email, err)
 		}
-		// store the refresh token to the db
-		// hashedRefreshToken := utils.PerformHash(refreshToken)
-		err = database.RedisClient.Set(ctx, "refresh_token:"+user.ID.String(), refreshToken, 168*time.Hour).Err()
 
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store the token"})
-			return
-		}
-
-		// send the response to the user
-		response := models.AuthResponse{
-			AccessToken:  accessToken,
-			RefreshToken: refreshToken,
-			User:         user,
-		}
-		ctx.JSON(http.StatusCreated, response)
+		respondWithTokens(ctx, http.StatusCreated, user)
 	}
 }
 
-func GetUsers() gin.HandlerFunc {
+// GetUser shows signed in users who someone is: only their id and name.
+// Admins see the whole account on /admin/users/:id.
+func GetUser() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		var users []models.User
 
-		result := db.Find(&users)
-		if result.Error != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the users"})
+		userId, err := uuid.Parse(ctx.Param("id"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 			return
 		}
 
-		ctx.JSON(http.StatusOK, users)
-	}
-}
-
-func GetUser() gin.HandlerFunc {
-	return func(ctx *gin.Context) {
-
-		userId := ctx.Param("id")
-
 		var user models.User
 
-		result := db.First(&user, "id = ?", userId)
+		result := db.Select("id", "name").First(&user, "id = ?", userId)
 
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
 		if result.Error != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the users"})
 			return
 		}
 
-		ctx.JSON(http.StatusOK, user)
+		ctx.JSON(http.StatusOK, models.Author{ID: user.ID, Name: user.Name})
 	}
 }
 
@@ -155,52 +141,137 @@ func Login() gin.HandlerFunc {
 			return
 		}
 
-		print(loginRequest.Email)
-		// validate if user already exists
-		var user models.User
-		res := db.Where("email = ?", loginRequest.Email).First(&user)
-
-		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not registered. Please register to continue."})
+		if !checkLoginLock(ctx, loginRequest.Email) {
 			return
 		}
-		if res.Error != nil {
+
+		// unknown emails and wrong passwords get the same answer in about the
+		// same time, so login can't be used to find out who has an account
+		var user models.User
+		res := db.Where("email = ?", loginRequest.Email).First(&user)
+		if res.Error != nil && !errors.Is(res.Error, gorm.ErrRecordNotFound) {
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			return
 		}
-
-		fmt.Println(loginRequest.Password)
-		// compare the password hash
-		validPassword, err := utils.CheckHash(loginRequest.Password, *user.PasswordHash)
-		if !validPassword {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": err})
+		if res.Error != nil || user.PasswordHash == nil {
+			utils.CheckNoHash(loginRequest.Password)
+			loginFailed(ctx, loginRequest.Email, user.ID)
 			return
 		}
-
-		// generate new tokens
-		accessToken, refreshToken, _ := utils.GenerateAccessAndRefreshToken(user.ID, user.Name, user.Email)
-
-		// update the tokens and store the new refresh token on redis
-		if err := utils.UpdateTokens(ctx, refreshToken, user.ID); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update refresh token"})
+		if validPassword, _ := utils.CheckHash(loginRequest.Password, *user.PasswordHash); !validPassword {
+			loginFailed(ctx, loginRequest.Email, user.ID)
 			return
 		}
-		// return the response
-		response := models.AuthResponse{
-			AccessToken:  accessToken,
-			RefreshToken: refreshToken,
-			User:         user,
+		utils.ResetLoginFailures(ctx, loginRequest.Email)
+
+		if utils.NeedsRehash(*user.PasswordHash) {
+			hashed := utils.PerformHash(loginRequest.Password)
+			if err := db.Model(&user).Update("password_hash", hashed).Error; err == nil {
+				user.PasswordHash = &hashed
+			}
 		}
-		ctx.JSON(http.StatusOK, response)
+
+		completeLogin(ctx, http.StatusOK, user)
 	}
 }
 
+// checkLoginLock writes a 429 and returns false while the account is locked
+// out after too many failed attempts.
+func checkLoginLock(ctx *gin.Context, account string) bool {
+	lockedFor, err := utils.LoginLockedFor(ctx, account)
+	if err != nil {
+		log.Printf("login lockout check failed: %v", err)
+		return true
+	}
+	if lockedFor > 0 {
+		middlewares.RetryAfter(ctx, lockedFor)
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, please try again later"})
+		return false
+	}
+	return true
+}
+
+// loginFailed counts a failed attempt against the account and writes the
+// response. userID is uuid.Nil when there is no such account.
+func loginFailed(ctx *gin.Context, account string, userID uuid.UUID) {
+	if _, err := utils.RecordLoginFailure(ctx, account); err != nil {
+		log.Printf("failed to record login failure: %v", err)
+	}
+	recordLoginFailure(ctx, account, userID, "password")
+	ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid email or password"})
+}
+
+// recordLoginFailure puts a failed attempt in the audit log, against the
+// account when it exists.
+func recordLoginFailure(ctx *gin.Context, account string, userID uuid.UUID, reason string) {
+	event := audit.Event{
+		Action:   audit.LoginFailed,
+		Metadata: map[string]interface{}{"email": account, "reason": reason},
+	}
+	if userID != uuid.Nil {
+		event.TargetType = audit.TargetUser
+		event.TargetID = audit.ID(userID)
+	}
+	audit.Record(ctx, event)
+}
+
+// respondWithTokens starts a new session for the user and sends its token
+// pair back with the user. It reports whether that worked.
+func respondWithTokens(ctx *gin.Context, status int, user models.User) bool {
+	if accountDisabled(ctx, user) {
+		return false
+	}
+	session, err := startSession(ctx, user.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start session"})
+		return false
+	}
+
+	accessToken, refreshToken, err := utils.GenerateAccessAndRefreshToken(user.ID, user.Name, user.Email, session.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating tokens"})
+		return false
+	}
+
+	// store the session's refresh token on redis
+	if err := utils.UpdateTokens(ctx, refreshToken, session.ID); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update refresh token"})
+		return false
+	}
+
+	ctx.JSON(status, models.AuthResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		User:         user,
+	})
+	return true
+}
+
+// verifyGoogleToken checks the id_token against Google's keys, writing the
+// error response itself when it's not acceptable.
+func verifyGoogleToken(ctx *gin.Context, idToken string) (*oauth.IDTokenClaims, bool) {
+	claims, err := oauth.VerifyGoogleIDToken(ctx.Request.Context(), idToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, oauth.ErrProviderNotConfig):
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "Google login is not configured"})
+		case errors.Is(err, oauth.ErrEmailNotVerified):
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Google account email is not verified"})
+		default:
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Google token"})
+		}
+		return nil, false
+	}
+	return claims, true
+}
+
+// LoginWithGoogle signs in with a Google ID token. The email and name come
+// from the verified token, never from the request body.
 func LoginWithGoogle() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
 		type LoginRequest struct {
-			Name     string `json:"name" validate:"required,min=2,max=30"`
-			Email    string `json:"email" validate:"email,required"`
+			IDToken string `json:"id_token" validate:"required"`
 		}
 
 		// parse the request body:
@@ -216,74 +287,133 @@ func LoginWithGoogle() gin.HandlerFunc {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
 			return
 		}
-		// check in the database if the username and email already exists, handle
-		var existingUser models.User
-		res := db.Where("email = ?", requestBody.Email).First(&existingUser)
 
-		if res.Error != nil {
-			if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
-				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-				return
-			}
-			// If we get here, it means the user doesn't exist (RecordNotFound)
-		} else {
-			if existingUser.Provider == "local" {
-				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Email already exists with password login. Use email/password to login."})
-				return
-			}
-
-			// otherwise the provider is google, so just refresh the token and return:
-			accessToken, refreshToken, _ := utils.GenerateAccessAndRefreshToken(existingUser.ID, existingUser.Name, existingUser.Email)
-
-		// update the tokens and store the new refresh token on redis
-		if err := utils.UpdateTokens(ctx, refreshToken, existingUser.ID); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update refresh token"})
+		claims, ok := verifyGoogleToken(ctx, requestBody.IDToken)
+		if !ok {
 			return
 		}
-		// return the response
-		response := models.AuthResponse{
-			AccessToken:  accessToken,
-			RefreshToken: refreshToken,
-			User:         existingUser,
+
+		signInWithIdentity(ctx, googleIdentity(claims))
+	}
+}
+
+func googleIdentity(claims *oauth.IDTokenClaims) *oauth.Identity {
+	return &oauth.Identity{
+		Provider:      "google",
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}
+}
+
+// LinkGoogleAccount links a Google account to an existing password account.
+// It needs both a valid Google ID token and the account's password, so
+// owning only one of them isn't enough to take the account over.
+func LinkGoogleAccount() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+
+		type LinkRequest struct {
+			IDToken  string `json:"id_token" validate:"required"`
+			Password string `json:"password" validate:"required"`
 		}
-			ctx.JSON(http.StatusOK, response)
+
+		var requestBody LinkRequest
+		if err := ctx.BindJSON(&requestBody); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
 			return
-			
 		}
-		// user does not exist
-		// create a user model
-		user := models.User{
-			ID:           uuid.New(),
-			Name:         requestBody.Name,
-			Email:        requestBody.Email,
-			Provider:     "google",
-			CreatedAt:    time.Now(),
-			UpdatedAt:    time.Now(),
+		if err := validator.New().Struct(requestBody); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
 		}
 
-		// store the user in database
-		if err := db.Create(&user).Error; err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		claims, ok := verifyGoogleToken(ctx, requestBody.IDToken)
+		if !ok {
 			return
 		}
 
-		// create the access and refresh token
-		accessToken, refreshToken, _ := utils.GenerateAccessAndRefreshToken(user.ID, user.Name, user.Email)
-
-		// update the tokens and store the new refresh token on redis
-		if err := utils.UpdateTokens(ctx, refreshToken, user.ID); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update refresh token"})
+		var user models.User
+		if err := db.Where("email = ?", claims.Email).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "No account to link. Sign in with Google instead."})
+			} else {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			}
 			return
 		}
+		if user.PasswordHash == nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Account has no password to confirm the link with"})
+			return
+		}
+		if !checkLoginLock(ctx, user.Email) {
+			return
+		}
+		if valid, _ := utils.CheckHash(requestBody.Password, *user.PasswordHash); !valid {
+			loginFailed(ctx, user.Email, user.ID)
+			return
+		}
+		utils.ResetLoginFailures(ctx, user.Email)
 
-		// send the response to the user
-		response := models.AuthResponse{
-			AccessToken:  accessToken,
-			RefreshToken: refreshToken,
-			User:         user,
+		var linked models.UserIdentity
+		err := db.Where("provider = ? AND subject = ?", "google", claims.Subject).First(&linked).Error
+		if err == nil && linked.UserID != user.ID {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "This Google account is already linked to another user"})
+			return
 		}
-		ctx.JSON(http.StatusCreated, response)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := createIdentity(db, user.ID, googleIdentity(claims)); err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link account"})
+				return
+			}
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		completeLogin(ctx, http.StatusOK, user)
+	}
+}
+
+// tokenProfile is what refreshed tokens carry, read fresh so profile changes
+// show up on the next refresh.
+func tokenProfile(userID uuid.UUID) (string, string, error) {
+	var user models.User
+	if err := db.Select("name", "email", "disabled_at").First(&user, "id = ?", userID).Error; err != nil {
+		return "", "", err
+	}
+	if user.DisabledAt != nil {
+		return "", "", errAccountDisabled
 	}
+	return user.Name, user.Email, nil
+}
+
+var errAccountDisabled = errors.New("account disabled")
+
+// accountDisabled turns away users an admin has disabled, writing the
+// response itself.
+func accountDisabled(ctx *gin.Context, user models.User) bool {
+	if user.DisabledAt == nil {
+		return false
+	}
+	ctx.JSON(http.StatusForbidden, gin.H{"error": "This account has been disabled"})
+	return true
+}
+
+// recordRefresh logs a refresh against the session's user, which the
+// refresh token doesn't hand back.
+func recordRefresh(ctx *gin.Context, sessionID uuid.UUID) {
+	var session models.Session
+	if err := db.Select("user_id").First(&session, "id = ?", sessionID).Error; err != nil {
+		log.Printf("Failed to look up session %s for the audit log: %v", sessionID, err)
+		return
+	}
+	audit.Record(ctx, audit.Event{
+		Action:     audit.TokenRefreshed,
+		ActorID:    audit.ID(session.UserID),
+		TargetType: audit.TargetSession,
+		TargetID:   audit.ID(sessionID),
+	})
 }
 
 func RefreshHandler() gin.HandlerFunc {
@@ -294,9 +424,15 @@ func RefreshHandler() gin.HandlerFunc {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": "no refresh token provided"})
 			return
 		}
-		newAccessToken, newRefreshToken, err := utils.RefreshTokens(refreshToken, context.Background())
+		newAccessToken, newRefreshToken, sessionId, err := utils.RefreshTokens(refreshToken, context.Background(), tokenProfile)
 
-		// fmt.Println(err.Error())
+		if errors.Is(err, utils.ErrRefreshTokenReused) {
+			revokeReusedSession(ctx, sessionId)
+		}
+		if errors.Is(err, errAccountDisabled) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "This account has been disabled"})
+			return
+		}
 		if err != nil {
 			ctx.JSON(
 				http.StatusUnauthorized,
@@ -305,6 +441,9 @@ func RefreshHandler() gin.HandlerFunc {
 
 			return
 		}
+		touchSession(ctx, sessionId)
+		recordRefresh(ctx, sessionId)
+
 		ctx.JSON(http.StatusOK, gin.H{
 			"access_token":  newAccessToken,
 			"refresh_token": newRefreshToken,
@@ -355,13 +494,26 @@ func Logout() gin.HandlerFunc {
 			return
 		}
 		
-		key := "refresh_token:" + userId.String()
-		err := database.RedisClient.Del(ctx, key).Err()
+		// the access token dies now instead of in up to 15 minutes
+		if claims, ok := ctx.Value("claims").(*utils.SignedDetails); ok {
+			if err := utils.DenyToken(ctx, claims); err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to logout"})
+				return
+			}
+		}
+
+		// only this device is signed out
+		_, err := revokeSessions(ctx, userId, "id = ?", currentSessionID(ctx))
 
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to logout"})
         	return
 		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.Logout,
+			TargetType: audit.TargetSession,
+			TargetID:   audit.ID(currentSessionID(ctx)),
+		})
 
 		ctx.JSON(http.StatusOK, gin.H{"success": "logout success"})
 	}
@@ -0,0 +1,433 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dipankarupd/text-editor/access"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/webhooks"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// webhookEvents checks the subscribed events and drops duplicates, writing a
+// 400 for unknown ones.
+func webhookEvents(ctx *gin.Context, events []string) ([]string, bool) {
+	if len(events) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Subscribe to at least one event", "events": webhooks.Events})
+		return nil, false
+	}
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, event := range events {
+		if !webhooks.ValidEvent(event) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Unknown event " + event, "events": webhooks.Events})
+			return nil, false
+		}
+		if !seen[event] {
+			seen[event] = true
+			unique = append(unique, event)
+		}
+	}
+	return unique, true
+}
+
+func validWebhookURL(ctx *gin.Context, raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "url must be an http or https URL"})
+		return false
+	}
+	if err := webhooks.CheckURL(u); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// createWebhook saves a webhook for the document or workspace and returns
+// it with its secret, which isn't shown again.
+func createWebhook(ctx *gin.Context, userID uuid.UUID, documentID, workspaceID *uuid.UUID) {
+	var body struct {
+		URL    string   `json:"url" validate:"required,max=2000"`
+		Events []string `json:"events" validate:"required,min=1"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+		return
+	}
+	if err := validator.New().Struct(body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return
+	}
+	if !validWebhookURL(ctx, body.URL) {
+		return
+	}
+	events, ok := webhookEvents(ctx, body.Events)
+	if !ok {
+		return
+	}
+
+	hook := models.Webhook{
+		ID:          uuid.New(),
+		DocumentID:  documentID,
+		WorkspaceID: workspaceID,
+		URL:         body.URL,
+		Secret:      webhooks.GenerateSecret(),
+		Events:      events,
+		Active:      true,
+		CreatedBy:   &userID,
+	}
+	if err := db.Create(&hook).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create the webhook"})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"webhook": hook, "secret": hook.Secret})
+}
+
+// CreateDocumentWebhook subscribes to one document; only its owner can.
+func CreateDocumentWebhook() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		doc, ok := loadDocument(ctx, userID, access.Owner)
+		if !ok {
+			return
+		}
+		createWebhook(ctx, userID, &doc.ID, nil)
+	}
+}
+
+// CreateWorkspaceWebhook subscribes to every document in a workspace, for
+// its admins.
+func CreateWorkspaceWebhook() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		workspace, role, ok := loadWorkspace(ctx, userID)
+		if !ok {
+			return
+		}
+		if role != models.WorkspaceRoleAdmin {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Only workspace admins can manage webhooks"})
+			return
+		}
+		createWebhook(ctx, userID, nil, &workspace.ID)
+	}
+}
+
+func GetDocumentWebhooks() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		doc, ok := loadDocument(ctx, userID, access.Owner)
+		if !ok {
+			return
+		}
+		var hooks []models.Webhook
+		if err := db.Where("document_id = ?", doc.ID).Order("created_at").Find(&hooks).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the webhooks"})
+			return
+		}
+		ctx.JSON(http.StatusOK, hooks)
+	}
+}
+
+func GetWorkspaceWebhooks() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		workspace, role, ok := loadWorkspace(ctx, userID)
+		if !ok {
+			return
+		}
+		if role != models.WorkspaceRoleAdmin {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Only workspace admins can manage webhooks"})
+			return
+		}
+		var hooks []models.Webhook
+		if err := db.Where("workspace_id = ?", workspace.ID).Order("created_at").Find(&hooks).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the webhooks"})
+			return
+		}
+		ctx.JSON(http.StatusOK, hooks)
+	}
+}
+
+// loadWebhook fetches the webhook named by the :id param if the caller may
+// manage it: the document's owner or an admin of the workspace. Anyone else
+// gets a 404.
+func loadWebhook(ctx *gin.Context, userID uuid.UUID) (*models.Webhook, bool) {
+	hookID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return nil, false
+	}
+	var hook models.Webhook
+	err = db.First(&hook, "id = ?", hookID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return nil, false
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+
+	allowed := false
+	if hook.DocumentID != nil {
+		var doc models.Document
+		if err := db.Omit("content").First(&doc, "id = ?", *hook.DocumentID).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return nil, false
+		}
+		level, err := access.DocumentLevel(userID, &doc)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return nil, false
+		}
+		allowed = level == access.Owner
+	} else if hook.WorkspaceID != nil {
+		role, err := access.WorkspaceRole(*hook.WorkspaceID, userID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return nil, false
+		}
+		allowed = role == models.WorkspaceRoleAdmin
+	}
+	if !allowed {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return nil, false
+	}
+	return &hook, true
+}
+
+func GetWebhook() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		hook, ok := loadWebhook(ctx, userID)
+		if !ok {
+			return
+		}
+		ctx.JSON(http.StatusOK, hook)
+	}
+}
+
+// UpdateWebhook changes the url, the events or turns the webhook on and off.
+// Pending deliveries of a webhook turned off fail instead of being sent.
+func UpdateWebhook() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			URL    *string  `json:"url" validate:"omitempty,max=2000"`
+			Events []string `json:"events" validate:"omitempty,min=1"`
+			Active *bool    `json:"active"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		hook, ok := loadWebhook(ctx, userID)
+		if !ok {
+			return
+		}
+
+		if body.URL != nil {
+			if !validWebhookURL(ctx, *body.URL) {
+				return
+			}
+			hook.URL = *body.URL
+		}
+		if body.Events != nil {
+			events, ok := webhookEvents(ctx, body.Events)
+			if !ok {
+				return
+			}
+			hook.Events = events
+		}
+		if body.Active != nil {
+			hook.Active = *body.Active
+		}
+		if err := db.Model(hook).Select("url", "events", "active", "updated_at").Updates(hook).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the webhook"})
+			return
+		}
+		ctx.JSON(http.StatusOK, hook)
+	}
+}
+
+// RotateWebhookSecret replaces the signing secret; the old one stops
+// working right away.
+func RotateWebhookSecret() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		hook, ok := loadWebhook(ctx, userID)
+		if !ok {
+			return
+		}
+		secret := webhooks.GenerateSecret()
+		if err := db.Model(hook).Updates(map[string]interface{}{"secret": secret, "updated_at": time.Now()}).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate the secret"})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{"secret": secret})
+	}
+}
+
+func DeleteWebhook() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		hook, ok := loadWebhook(ctx, userID)
+		if !ok {
+			return
+		}
+		if err := db.Delete(hook).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete the webhook"})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{"success": "ok"})
+	}
+}
+
+// PingWebhook queues a ping event, to check the receiver is reachable and
+// verifies signatures.
+func PingWebhook() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		hook, ok := loadWebhook(ctx, userID)
+		if !ok {
+			return
+		}
+		if !hook.Active {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "Turn the webhook back on first"})
+			return
+		}
+		payload := gin.H{"id": uuid.New(), "event": webhooks.Ping, "created_at": time.Now().UTC(), "webhook_id": hook.ID}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue the ping"})
+			return
+		}
+		delivery, err := webhooks.Enqueue(hook.ID, webhooks.Ping, body)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue the ping"})
+			return
+		}
+		ctx.JSON(http.StatusAccepted, delivery)
+	}
+}
+
+// GetWebhookDeliveries is the delivery log, newest first. ?status= filters
+// by pending, succeeded or failed.
+func GetWebhookDeliveries() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		hook, ok := loadWebhook(ctx, userID)
+		if !ok {
+			return
+		}
+		page, perPage := pageParams(ctx)
+
+		query := db.Model(&models.WebhookDelivery{}).Where("webhook_id = ?", hook.ID)
+		if status := ctx.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		var total int64
+		if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the deliveries"})
+			return
+		}
+		var deliveries []models.WebhookDelivery
+		err := query.Order("created_at DESC").
+			Offset((page - 1) * perPage).
+			Limit(perPage).
+			Find(&deliveries).Error
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the deliveries"})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"deliveries": deliveries,
+			"total":      total,
+			"page":       page,
+			"per_page":   perPage,
+		})
+	}
+}
+
+// RedeliverWebhook sends an earlier delivery again, as a new entry in the
+// log with the same payload.
+func RedeliverWebhook() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		deliveryID, err := uuid.Parse(ctx.Param("deliveryId"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+			return
+		}
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		hook, ok := loadWebhook(ctx, userID)
+		if !ok {
+			return
+		}
+		if !hook.Active {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "Turn the webhook back on first"})
+			return
+		}
+
+		var original models.WebhookDelivery
+		err = db.First(&original, "id = ? AND webhook_id = ?", deliveryID, hook.ID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+			return
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		delivery, err := webhooks.Enqueue(hook.ID, original.Event, original.Payload)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue the delivery"})
+			return
+		}
+		ctx.JSON(http.StatusAccepted, delivery)
+	}
+}
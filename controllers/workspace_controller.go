@@ -0,0 +1,394 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dipankarupd/text-editor/access"
+	"github.com/dipankarupd/text-editor/audit"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/notify"
+	"github.com/dipankarupd/text-editor/ws"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// loadWorkspace fetches the workspace named by the :id param and returns the
+// caller's role in it. Non-members get a 404 so workspace ids don't leak.
+func loadWorkspace(ctx *gin.Context, userID uuid.UUID) (*models.Workspace, string, bool) {
+	workspaceID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workspace ID"})
+		return nil, "", false
+	}
+
+	role, err := access.WorkspaceRole(workspaceID, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, "", false
+	}
+	if role == "" {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return nil, "", false
+	}
+
+	var workspace models.Workspace
+	if err := db.First(&workspace, "id = ?", workspaceID).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, "", false
+	}
+	return &workspace, role, true
+}
+
+func CreateWorkspace() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Name string `json:"name" validate:"required,min=1,max=100"`
+		}
+		if err := ctx.ShouldBindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		workspace := models.Workspace{
+			ID:        uuid.New(),
+			Name:      body.Name,
+			OwnerID:   userID,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&workspace).Error; err != nil {
+				return err
+			}
+			return tx.Create(&models.WorkspaceMember{
+				WorkspaceID: workspace.ID,
+				UserID:      userID,
+				Role:        models.WorkspaceRoleAdmin,
+				CreatedAt:   time.Now(),
+			}).Error
+		})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workspace"})
+			return
+		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.WorkspaceCreated,
+			TargetType: audit.TargetWorkspace,
+			TargetID:   audit.ID(workspace.ID),
+			Metadata:   map[string]interface{}{"name": workspace.Name},
+		})
+
+		ctx.JSON(http.StatusCreated, models.WorkspaceResponse{Workspace: workspace, Role: models.WorkspaceRoleAdmin})
+	}
+}
+
+func GetWorkspaces() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+
+		var workspaces []models.WorkspaceResponse
+		err := db.Table("workspaces").
+			Select("workspaces.*, workspace_members.role").
+			Joins("JOIN workspace_members ON workspace_members.workspace_id = workspaces.id").
+			Where("workspace_members.user_id = ?", userID).
+			Order("workspaces.name").
+			Scan(&workspaces).Error
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the workspaces"})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, workspaces)
+	}
+}
+
+func GetWorkspace() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		workspace, role, ok := loadWorkspace(ctx, userID)
+		if !ok {
+			return
+		}
+
+		var members []models.WorkspaceMemberResponse
+		err := db.Table("workspace_members").
+			Select("users.id AS user_id, users.name, users.email, workspace_members.role, workspace_members.created_at").
+			Joins("JOIN users ON users.id = workspace_members.user_id").
+			Where("workspace_members.workspace_id = ?", workspace.ID).
+			Order("users.name").
+			Scan(&members).Error
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the members"})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"workspace": models.WorkspaceResponse{Workspace: *workspace, Role: role},
+			"members":   members,
+		})
+	}
+}
+
+// AddWorkspaceMember invites an existing user by email.
+func AddWorkspaceMember() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Email string `json:"email" validate:"required,email"`
+			Role  string `json:"role" validate:"required,oneof=admin member guest"`
+		}
+		if err := ctx.ShouldBindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		workspace, role, ok := loadWorkspace(ctx, userID)
+		if !ok {
+			return
+		}
+		if role != models.WorkspaceRoleAdmin {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Only workspace admins can invite members"})
+			return
+		}
+
+		var invitee models.User
+		if err := db.Where("email = ?", body.Email).First(&invitee).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "No user with that email"})
+			} else {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			}
+			return
+		}
+
+		existing, err := access.WorkspaceRole(workspace.ID, invitee.ID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if existing != "" {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "User is already a member"})
+			return
+		}
+
+		member := models.WorkspaceMember{
+			WorkspaceID: workspace.ID,
+			UserID:      invitee.ID,
+			Role:        body.Role,
+			CreatedAt:   time.Now(),
+		}
+		if err := db.Create(&member).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add member"})
+			return
+		}
+		audit.Record(ctx, audit.Event{
+			Action:     audit.WorkspaceMemberAdded,
+			TargetType: audit.TargetWorkspace,
+			TargetID:   audit.ID(workspace.ID),
+			Metadata:   map[string]interface{}{"user_id": invitee.ID, "role": member.Role},
+		})
+		notify.Send(ctx, notify.Event{
+			Type:        notify.AddedToWorkspace,
+			UserID:      invitee.ID,
+			WorkspaceID: &workspace.ID,
+			Data:        map[string]interface{}{"workspace_name": workspace.Name, "role": member.Role},
+		})
+
+		ctx.JSON(http.StatusCreated, models.WorkspaceMemberResponse{
+			UserID:    invitee.ID,
+			Name:      invitee.Name,
+			Email:     invitee.Email,
+			Role:      member.Role,
+			CreatedAt: member.CreatedAt,
+		})
+	}
+}
+
+func UpdateWorkspaceMember() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body struct {
+			Role string `json:"role" validate:"required,oneof=admin member guest"`
+		}
+		if err := ctx.ShouldBindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request Body"})
+			return
+		}
+		if err := validator.New().Struct(body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+			return
+		}
+		memberID, err := uuid.Parse(ctx.Param("userId"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		workspace, role, ok := loadWorkspace(ctx, userID)
+		if !ok {
+			return
+		}
+		if role != models.WorkspaceRoleAdmin {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Only workspace admins can change roles"})
+			return
+		}
+		if memberID == workspace.OwnerID {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "The workspace owner is always an admin"})
+			return
+		}
+
+		res := db.Model(&models.WorkspaceMember{}).
+			Where("workspace_id = ? AND user_id = ?", workspace.ID, memberID).
+			Update("role", body.Role)
+		if res.Error != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+			return
+		}
+		if res.RowsAffected == 0 {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+		ws.RecheckAccess(memberID)
+		audit.Record(ctx, audit.Event{
+			Action:     audit.WorkspaceMemberRoleChanged,
+			TargetType: audit.TargetWorkspace,
+			TargetID:   audit.ID(workspace.ID),
+			Metadata:   map[string]interface{}{"user_id": memberID, "role": body.Role},
+		})
+		notify.Send(ctx, notify.Event{
+			Type:        notify.WorkspaceRoleChanged,
+			UserID:      memberID,
+			WorkspaceID: &workspace.ID,
+			Data:        map[string]interface{}{"workspace_name": workspace.Name, "role": body.Role},
+		})
+
+		ctx.JSON(http.StatusOK, gin.H{"success": "ok", "role": body.Role})
+	}
+}
+
+// RemoveWorkspaceMember lets admins remove anyone but the owner, and members
+// leave on their own.
+func RemoveWorkspaceMember() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		memberID, err := uuid.Parse(ctx.Param("userId"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		workspace, role, ok := loadWorkspace(ctx, userID)
+		if !ok {
+			return
+		}
+		if role != models.WorkspaceRoleAdmin && memberID != userID {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Only workspace admins can remove members"})
+			return
+		}
+		if memberID == workspace.OwnerID {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "The workspace owner can't be removed"})
+			return
+		}
+
+		res := db.Where("workspace_id = ? AND user_id = ?", workspace.ID, memberID).Delete(&models.WorkspaceMember{})
+		if res.Error != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+			return
+		}
+		if res.RowsAffected == 0 {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+		// drops them from the workspace's documents they have open
+		ws.RecheckAccess(memberID)
+		audit.Record(ctx, audit.Event{
+			Action:     audit.WorkspaceMemberRemoved,
+			TargetType: audit.TargetWorkspace,
+			TargetID:   audit.ID(workspace.ID),
+			Metadata:   map[string]interface{}{"user_id": memberID},
+		})
+		notify.Send(ctx, notify.Event{
+			Type:        notify.RemovedFromWorkspace,
+			UserID:      memberID,
+			WorkspaceID: &workspace.ID,
+			Data:        map[string]interface{}{"workspace_name": workspace.Name},
+		})
+
+		ctx.JSON(http.StatusOK, gin.H{"success": "ok"})
+	}
+}
+
+// documentText is a document's plain text: its text inserts joined up, so
+// the JSON keys, attributes and embeds aren't searched and a word split by
+// formatting still matches.
+const documentText = `(SELECT string_agg(t #>> '{}', '') FROM jsonb_path_query(content, '$[*].insert') t WHERE jsonb_typeof(t) = 'string')`
+
+// GetWorkspaceDocuments lists a workspace's documents; ?q= searches titles
+// and content.
+func GetWorkspaceDocuments() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, ok := currentUserID(ctx)
+		if !ok {
+			return
+		}
+		workspace, _, ok := loadWorkspace(ctx, userID)
+		if !ok {
+			return
+		}
+
+		query := db.Where("workspace_id = ?", workspace.ID)
+		if q := ctx.Query("q"); q != "" {
+			pattern := "%" + escapeLike(q) + "%"
+			query = query.Where("(title ILIKE ? OR description ILIKE ? OR "+documentText+" ILIKE ?)", pattern, pattern, pattern)
+		}
+		query, ok = filterDocuments(ctx, query)
+		if !ok {
+			return
+		}
+
+		var docs []models.Document
+		if err := query.Order("updated_at DESC").Find(&docs).Error; err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the documents"})
+			return
+		}
+
+		docResponses, err := toDocResponses(docs)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching the authors"})
+			return
+		}
+		ctx.JSON(http.StatusOK, docResponses)
+	}
+}
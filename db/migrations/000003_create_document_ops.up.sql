@@ -0,0 +1,22 @@
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS document_ops (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    document_id UUID NOT NULL,
+    user_id UUID,
+    revision BIGINT NOT NULL,
+    delta JSONB NOT NULL,
+    inverse JSONB NOT NULL,
+    source TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT fk_document_ops_document
+        FOREIGN KEY (document_id)
+        REFERENCES documents(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_document_ops_user
+        FOREIGN KEY (user_id)
+        REFERENCES users(id)
+        ON DELETE SET NULL,
+    CONSTRAINT uq_document_ops_revision UNIQUE (document_id, revision)
+);
@@ -0,0 +1,8 @@
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS forked_from_id UUID;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS forked_from_revision BIGINT;
+
+ALTER TABLE documents
+    ADD CONSTRAINT fk_documents_forked_from
+        FOREIGN KEY (forked_from_id)
+        REFERENCES documents(id)
+        ON DELETE SET NULL;
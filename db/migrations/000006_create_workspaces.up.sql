@@ -0,0 +1,48 @@
+CREATE TABLE IF NOT EXISTS workspaces (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL,
+    owner_id UUID NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT fk_workspaces_owner
+        FOREIGN KEY (owner_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS workspace_members (
+    workspace_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('admin', 'member', 'guest')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (workspace_id, user_id),
+    CONSTRAINT fk_workspace_members_workspace
+        FOREIGN KEY (workspace_id)
+        REFERENCES workspaces(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_workspace_members_user
+        FOREIGN KEY (user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_workspace_members_user ON workspace_members(user_id);
+
+-- documents and templates that belong to a workspace fall back to their
+-- author when the workspace goes away
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS workspace_id UUID;
+ALTER TABLE documents
+    ADD CONSTRAINT fk_documents_workspace
+        FOREIGN KEY (workspace_id)
+        REFERENCES workspaces(id)
+        ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_documents_workspace ON documents(workspace_id);
+
+ALTER TABLE templates ADD COLUMN IF NOT EXISTS workspace_id UUID;
+ALTER TABLE templates
+    ADD CONSTRAINT fk_templates_workspace
+        FOREIGN KEY (workspace_id)
+        REFERENCES workspaces(id)
+        ON DELETE SET NULL;
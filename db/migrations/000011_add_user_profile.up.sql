@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_url TEXT;
+
+-- extra payload for a token, e.g. the new address of an email change
+ALTER TABLE user_tokens ADD COLUMN IF NOT EXISTS data TEXT;
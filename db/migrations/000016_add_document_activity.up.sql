@@ -0,0 +1,54 @@
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS last_edited_by UUID;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS last_edited_at TIMESTAMPTZ;
+
+ALTER TABLE documents DROP CONSTRAINT IF EXISTS fk_documents_last_edited_by;
+ALTER TABLE documents ADD CONSTRAINT fk_documents_last_edited_by
+    FOREIGN KEY (last_edited_by)
+    REFERENCES users(id)
+    ON DELETE SET NULL;
+
+UPDATE documents d SET last_edited_by = o.user_id, last_edited_at = o.created_at
+FROM (
+    SELECT DISTINCT ON (document_id) document_id, user_id, created_at
+    FROM document_ops
+    WHERE user_id IS NOT NULL
+    ORDER BY document_id, revision DESC
+) o
+WHERE o.document_id = d.id;
+
+-- the documents each user opened or edited, for their recent documents
+CREATE TABLE IF NOT EXISTS document_visits (
+    user_id UUID NOT NULL,
+    document_id UUID NOT NULL,
+    last_opened_at TIMESTAMPTZ,
+    last_edited_at TIMESTAMPTZ,
+    last_activity_at TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY (user_id, document_id),
+
+    CONSTRAINT fk_document_visits_user
+        FOREIGN KEY (user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+
+    CONSTRAINT fk_document_visits_document
+        FOREIGN KEY (document_id)
+        REFERENCES documents(id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_visits_recent ON document_visits(user_id, last_activity_at DESC);
+
+-- start everyone off with what they wrote or edited so far
+INSERT INTO document_visits (user_id, document_id, last_activity_at)
+SELECT author_id, id, updated_at FROM documents
+ON CONFLICT DO NOTHING;
+
+INSERT INTO document_visits (user_id, document_id, last_edited_at, last_activity_at)
+SELECT o.user_id, o.document_id, max(o.created_at), max(o.created_at)
+FROM document_ops o
+JOIN users u ON u.id = o.user_id
+GROUP BY o.user_id, o.document_id
+ON CONFLICT (user_id, document_id) DO UPDATE SET
+    last_edited_at = EXCLUDED.last_edited_at,
+    last_activity_at = GREATEST(document_visits.last_activity_at, EXCLUDED.last_activity_at);
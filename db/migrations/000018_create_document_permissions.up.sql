@@ -0,0 +1,54 @@
+-- access to single documents, given to people outside their workspace
+CREATE TABLE IF NOT EXISTS document_permissions (
+    document_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('viewer', 'editor')),
+    granted_by UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (document_id, user_id),
+    CONSTRAINT fk_document_permissions_document
+        FOREIGN KEY (document_id)
+        REFERENCES documents(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_document_permissions_user
+        FOREIGN KEY (user_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_document_permissions_granted_by
+        FOREIGN KEY (granted_by)
+        REFERENCES users(id)
+        ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_permissions_user ON document_permissions(user_id);
+
+CREATE TABLE IF NOT EXISTS access_requests (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    document_id UUID NOT NULL,
+    requester_id UUID NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('viewer', 'editor')),
+    message TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'denied')),
+    decided_by UUID,
+    decided_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT fk_access_requests_document
+        FOREIGN KEY (document_id)
+        REFERENCES documents(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_access_requests_requester
+        FOREIGN KEY (requester_id)
+        REFERENCES users(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_access_requests_decided_by
+        FOREIGN KEY (decided_by)
+        REFERENCES users(id)
+        ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_requests_document ON access_requests(document_id, created_at DESC);
+-- one open request per person and document
+CREATE UNIQUE INDEX IF NOT EXISTS idx_access_requests_pending
+    ON access_requests(document_id, requester_id) WHERE status = 'pending';
@@ -0,0 +1,61 @@
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+
+-- tags are shared by name; documents point at them through document_tags
+CREATE TABLE IF NOT EXISTS tags (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS document_tags (
+    document_id UUID NOT NULL,
+    tag_id UUID NOT NULL,
+
+    PRIMARY KEY (document_id, tag_id),
+    CONSTRAINT fk_document_tags_document
+        FOREIGN KEY (document_id)
+        REFERENCES documents(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_document_tags_tag
+        FOREIGN KEY (tag_id)
+        REFERENCES tags(id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_tags_tag ON document_tags(tag_id);
+
+-- custom properties a workspace defines for its documents
+CREATE TABLE IF NOT EXISTS workspace_properties (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    workspace_id UUID NOT NULL,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL CHECK (type IN ('text', 'number', 'date', 'select')),
+    options JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT fk_workspace_properties_workspace
+        FOREIGN KEY (workspace_id)
+        REFERENCES workspaces(id)
+        ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_workspace_properties_name ON workspace_properties(workspace_id, lower(name));
+
+CREATE TABLE IF NOT EXISTS document_property_values (
+    document_id UUID NOT NULL,
+    property_id UUID NOT NULL,
+    value JSONB NOT NULL,
+
+    PRIMARY KEY (document_id, property_id),
+    CONSTRAINT fk_document_property_values_document
+        FOREIGN KEY (document_id)
+        REFERENCES documents(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_document_property_values_property
+        FOREIGN KEY (property_id)
+        REFERENCES workspace_properties(id)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_property_values_property ON document_property_values(property_id);
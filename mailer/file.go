@@ -0,0 +1,26 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileSender writes each message to its own .eml file in Dir, which any mail
+// client can open.
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+func (s *FileSender) Send(_ context.Context, msg Message) error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	to := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(msg.To)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000"), to)
+	return os.WriteFile(filepath.Join(s.Dir, name), format(s.From, msg), 0o644)
+}
@@ -0,0 +1,101 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers mail. Pick one with MAIL_DRIVER; "log" and "file" are for
+// local setups where nothing should leave the machine.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var Default Sender = LogSender{}
+
+// Init picks the sender from MAIL_DRIVER: "smtp", "file" or "log" (the
+// default, with a warning).
+func Init() {
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "smtp":
+		Default = &SMTPSender{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     envOr("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from(),
+		}
+	case "file":
+		Default = &FileSender{Dir: envOr("MAIL_DIR", "mail"), From: from()}
+	case "":
+		log.Println("⚠️ MAIL_DRIVER is not set, mail will only be logged and its links redacted; set it to smtp to send mail")
+		Default = LogSender{}
+	case "log":
+		Default = LogSender{}
+	default:
+		log.Printf("⚠️ Unknown MAIL_DRIVER %q, mail will only be logged", driver)
+		Default = LogSender{}
+	}
+}
+
+func Send(ctx context.Context, msg Message) error {
+	return Default.Send(ctx, msg)
+}
+
+// SendAsync sends in the background and only logs failures, so requests
+// don't wait on the mail server and don't behave differently when it's down.
+func SendAsync(msg Message) {
+	go func() {
+		if err := Send(context.Background(), msg); err != nil {
+			log.Printf("Failed to send %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
+
+// tokens in emailed links are as good as a password for the account
+var linkToken = regexp.MustCompile(`([?&]token=)[^\s&]+`)
+
+// LogSender writes mail to the server log, with the tokens in its links
+// redacted, as logs tend to end up in places mail shouldn't. Use the file
+// driver to follow the links locally.
+type LogSender struct{}
+
+func (LogSender) Send(_ context.Context, msg Message) error {
+	body := linkToken.ReplaceAllString(msg.Body, "${1}[redacted]")
+	log.Printf("📧 To: %s\nSubject: %s\n\n%s", msg.To, msg.Subject, body)
+	return nil
+}
+
+func from() string {
+	return envOr("MAIL_FROM", "Collaborative Editor <no-reply@localhost>")
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// format renders the message as RFC 5322 text.
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
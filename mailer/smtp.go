@@ -0,0 +1,50 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPSender sends through an SMTP server, using STARTTLS when the server
+// offers it (net/smtp does that on its own).
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	if s.Host == "" {
+		return errors.New("SMTP_HOST is not set")
+	}
+	// header injection would let a crafted address add recipients
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return errors.New("invalid header value")
+	}
+	sender, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(s.Host, s.Port), auth, sender.Address, []string{msg.To}, format(s.From, msg))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -2,14 +2,25 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/dipankarupd/text-editor/access"
+	"github.com/dipankarupd/text-editor/audit"
 	"github.com/dipankarupd/text-editor/controllers"
 	"github.com/dipankarupd/text-editor/db"
+	"github.com/dipankarupd/text-editor/mailer"
 	"github.com/dipankarupd/text-editor/middlewares"
+	"github.com/dipankarupd/text-editor/notify"
+	"github.com/dipankarupd/text-editor/oauth"
+	"github.com/dipankarupd/text-editor/pat"
 	"github.com/dipankarupd/text-editor/routes"
+	"github.com/dipankarupd/text-editor/signing"
+	"github.com/dipankarupd/text-editor/utils"
+	"github.com/dipankarupd/text-editor/webhooks"
 	"github.com/dipankarupd/text-editor/ws"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -35,13 +46,27 @@ func main() {
 	db.InitRedis()
 
 	controllers.InitControllers(database)
+	controllers.BootstrapAdmins()
 	ws.InitDb(database)
+	access.InitDb(database)
+	signing.InitDb(database)
+	signing.InitCipher(utils.Encrypt, utils.Decrypt)
+	pat.InitDb(database)
+	audit.InitDb(database)
+	notify.InitDb(database)
+	webhooks.InitDb(database)
+	signing.Start()
+	notify.StartDigests()
+	webhooks.Start()
+	oauth.InitGoogle()
+	oauth.InitProviders()
+	mailer.Init()
 
 	config := cors.Config{
 		AllowOrigins:     []string{"https://collaborative-text-edito-92724.web.app"}, // frontend URL
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
 		AllowHeaders: []string{"*"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}
@@ -50,6 +75,19 @@ func main() {
 
 	router := gin.New()
 
+	// X-Forwarded-For is only believed from the proxies named here; without
+	// any, ClientIP is the address that connected, which clients can't forge
+	var trustedProxies []string
+	if raw := os.Getenv("TRUSTED_PROXIES"); raw != "" {
+		trustedProxies = strings.Split(raw, ",")
+		for i := range trustedProxies {
+			trustedProxies[i] = strings.TrimSpace(trustedProxies[i])
+		}
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("❌ Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	router.Use(cors.New(config))
 
 	router.Use(gin.Logger())
@@ -60,14 +98,21 @@ func main() {
 	})
 
 	routes.UserRoutes(router)
+	routes.WellKnownRoutes(router)
 	routes.WebSocketRoutes(router)
 		
 
 	router.Use(middlewares.Authentication())
+	router.Use(middlewares.Compress(1024))
 
 	routes.UserSecureRoutes(router)
 
 	routes.DocumentRoutes(router)
+	routes.TemplateRoutes(router)
+	routes.WorkspaceRoutes(router)
+	routes.AdminRoutes(router)
+	routes.NotificationRoutes(router)
+	routes.WebhookRoutes(router)
 	
 
 
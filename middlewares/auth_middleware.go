@@ -1,12 +1,41 @@
 package middlewares
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 
+	"github.com/dipankarupd/text-editor/pat"
 	"github.com/dipankarupd/text-editor/utils"
 	"github.com/gin-gonic/gin"
 )
 
+// routeScopes is what a personal access token needs for each route. Routes
+// that aren't listed can only be used with a signed in session, so managing
+// the account or its tokens always takes a real login.
+var routeScopes = map[string]string{
+	"GET /users/me":                   pat.ScopeUsersRead,
+	"GET /documents/me":               pat.ScopeDocumentsRead,
+	"GET /documents/recent":           pat.ScopeDocumentsRead,
+	"GET /documents/:id":              pat.ScopeDocumentsRead,
+	"GET /documents/:id/activity":     pat.ScopeDocumentsRead,
+	"GET /documents/:id/contributors": pat.ScopeDocumentsRead,
+	"GET /templates":                  pat.ScopeDocumentsRead,
+	"GET /workspaces":                 pat.ScopeDocumentsRead,
+	"GET /workspaces/:id":             pat.ScopeDocumentsRead,
+	"GET /workspaces/:id/documents":   pat.ScopeDocumentsRead,
+	"GET /workspaces/:id/tags":        pat.ScopeDocumentsRead,
+	"GET /workspaces/:id/properties":  pat.ScopeDocumentsRead,
+	"POST /documents":                 pat.ScopeDocumentsWrite,
+	"PATCH /documents/:id":            pat.ScopeDocumentsWrite,
+	"PATCH /documents/:id/content":    pat.ScopeDocumentsWrite,
+	"PATCH /documents/:id/metadata":   pat.ScopeDocumentsWrite,
+	"POST /documents/:id/sync":        pat.ScopeDocumentsWrite,
+	"POST /documents/:id/template":    pat.ScopeDocumentsWrite,
+	"POST /documents/:id/copy":        pat.ScopeDocumentsWrite,
+	"DELETE /templates/:id":           pat.ScopeDocumentsWrite,
+}
+
 func Authentication() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
@@ -17,13 +46,21 @@ func Authentication() gin.HandlerFunc {
 
 		// middleware code:
 		token := ctx.Request.Header.Get("token")
+		if token == "" {
+			token = strings.TrimPrefix(ctx.Request.Header.Get("Authorization"), "Bearer ")
+		}
 		if token == "" {
 			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "no token provided"})
 			ctx.Abort()
 			return
 		}
 
-		claims, msg := utils.ValidateToken(token)
+		if pat.IsToken(token) {
+			authenticateAccessToken(ctx, token)
+			return
+		}
+
+		claims, msg := utils.ValidateActiveToken(ctx.Request.Context(), token)
 		if msg != "" {
 
 			if msg == "token expired" {
@@ -42,5 +79,45 @@ func Authentication() gin.HandlerFunc {
 		ctx.Set("name", claims.Name)
 		ctx.Set("email", claims.Email)
 		ctx.Set("userid", claims.UserId)
+		ctx.Set("sessionid", claims.SessionId)
+		ctx.Set("claims", claims)
+	}
+}
+
+// authenticateAccessToken signs the request in with a personal access
+// token, if the token has the scope the route needs.
+func authenticateAccessToken(ctx *gin.Context, raw string) {
+	token, user, err := pat.Authenticate(raw, ctx.ClientIP())
+	if errors.Is(err, pat.ErrInvalidToken) || errors.Is(err, pat.ErrExpiredToken) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		ctx.Abort()
+		return
+	}
+	if errors.Is(err, pat.ErrUserDisabled) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "This account has been disabled"})
+		ctx.Abort()
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not check token"})
+		ctx.Abort()
+		return
 	}
+
+	scope, ok := routeScopes[ctx.Request.Method+" "+ctx.FullPath()]
+	if !ok {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "this endpoint can't be used with an access token"})
+		ctx.Abort()
+		return
+	}
+	if !pat.Has(token.Scopes, scope) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "access token is missing the " + scope + " scope"})
+		ctx.Abort()
+		return
+	}
+
+	ctx.Set("name", user.Name)
+	ctx.Set("email", user.Email)
+	ctx.Set("userid", user.ID)
+	ctx.Set("tokenid", token.ID)
 }
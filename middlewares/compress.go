@@ -0,0 +1,103 @@
+package middlewares
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GzipETagSuffix marks the ETag of a gzipped response, which is a different
+// representation from the identity one and so gets a different tag.
+const GzipETagSuffix = "-gzip"
+
+var gzipWriters = sync.Pool{New: func() interface{} {
+	w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+	return w
+}}
+
+// Compress gzips responses of at least minSize bytes for clients that accept
+// it; smaller ones aren't worth it. Brotli isn't offered, the standard
+// library has no encoder for it.
+func Compress(minSize int) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Writer.Header().Add("Vary", "Accept-Encoding")
+		if ctx.Request.Method == http.MethodHead || !acceptsGzip(ctx.GetHeader("Accept-Encoding")) {
+			ctx.Next()
+			return
+		}
+
+		w := &gzipWriter{ResponseWriter: ctx.Writer, minSize: minSize}
+		ctx.Writer = w
+		defer w.close()
+		ctx.Next()
+	}
+}
+
+// acceptsGzip reads an Accept-Encoding header, e.g. "gzip, deflate, br" or
+// "gzip;q=0" for a client that refuses it.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipWriter decides on the first write whether the response is compressed;
+// handlers write JSON in one go, so that's the whole body.
+type gzipWriter struct {
+	gin.ResponseWriter
+	minSize int
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		h := w.Header()
+		if len(b) >= w.minSize && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length")
+			if etag := h.Get("ETag"); strings.HasSuffix(etag, `"`) {
+				h.Set("ETag", strings.TrimSuffix(etag, `"`)+GzipETagSuffix+`"`)
+			}
+			w.gz = gzipWriters.Get().(*gzip.Writer)
+			w.gz.Reset(w.ResponseWriter)
+		}
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+}
+
+func compressible(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/json") || strings.HasPrefix(contentType, "text/")
+}
@@ -0,0 +1,73 @@
+package middlewares
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	database "github.com/dipankarupd/text-editor/db"
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit allows limit requests per window for each key, counted in redis
+// so every instance shares the budget. Requests over it get a 429 with
+// Retry-After. Use it on single routes or on a route group:
+//
+//	route.POST("/users/login", middlewares.RateLimit("login", 10, time.Minute, middlewares.ByIP), controllers.Login())
+func RateLimit(name string, limit int, window time.Duration, key func(ctx *gin.Context) string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		now := time.Now()
+		slot := now.UnixNano() / int64(window)
+		redisKey := fmt.Sprintf("rate:%s:%s:%d", name, key(ctx), slot)
+
+		pipe := database.RedisClient.TxPipeline()
+		count := pipe.Incr(ctx, redisKey)
+		pipe.Expire(ctx, redisKey, window)
+		if _, err := pipe.Exec(ctx); err != nil {
+			// an outage of the limiter shouldn't take the API down with it
+			log.Printf("rate limit %s: %v", name, err)
+			ctx.Next()
+			return
+		}
+
+		remaining := limit - int(count.Val())
+		if remaining < 0 {
+			remaining = 0
+		}
+		ctx.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+		ctx.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+
+		if int(count.Val()) > limit {
+			resetAt := time.Unix(0, (slot+1)*int64(window))
+			RetryAfter(ctx, resetAt.Sub(now))
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please try again later"})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// ByIP keys a rate limit on the client address.
+func ByIP(ctx *gin.Context) string {
+	return ctx.ClientIP()
+}
+
+// ByUser keys a rate limit on the signed in user, falling back to the
+// address; use it behind Authentication.
+func ByUser(ctx *gin.Context) string {
+	if userID, ok := ctx.Get("userid"); ok {
+		return fmt.Sprint(userID)
+	}
+	return ctx.ClientIP()
+}
+
+// RetryAfter sets the Retry-After header, in whole seconds rounded up.
+func RetryAfter(ctx *gin.Context, wait time.Duration) {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	ctx.Header("Retry-After", strconv.Itoa(seconds))
+}
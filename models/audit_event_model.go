@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditEvent records who did what, from where. Rows are never changed.
+type AuditEvent struct {
+	ID         uuid.UUID              `gorm:"type:uuid;primaryKey" json:"id"`
+	CreatedAt  time.Time              `gorm:"autoCreateTime" json:"created_at"`
+	Action     string                 `gorm:"not null" json:"action"`
+	ActorID    *uuid.UUID             `gorm:"type:uuid" json:"actor_id"`
+	IPAddress  string                 `json:"ip_address,omitempty"`
+	UserAgent  string                 `json:"user_agent,omitempty"`
+	TargetType string                 `json:"target_type,omitempty"`
+	TargetID   *uuid.UUID             `gorm:"type:uuid" json:"target_id"`
+	Metadata   map[string]interface{} `gorm:"serializer:json;type:jsonb;not null" json:"metadata"`
+}
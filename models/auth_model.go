@@ -12,11 +12,16 @@ type AuthResponse struct {
 	User         User   `json:"user"`
 }
 
+// Session is one signed in device. Its refresh token lives in redis under
+// "refresh_token:<session id>"; the row keeps what we show in the device list.
 type Session struct {
-	ID        uuid.UUID `db:"id"`
-	UserId    uuid.UUID `db:"user_id"`
-	Token     string    `db:"token"`
-	CreatedAt time.Time `db:"created_at"`
-	ExpiresAt time.Time `db:"expires_at"`
-	Revoked   bool      `db:"revoked"`
+	ID         uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null" json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"-"`
+	Current    bool       `gorm:"-" json:"current"`
 }
@@ -6,25 +6,70 @@ import (
 
 	"github.com/google/uuid"
 )
+
 type Document struct {
-	ID        uuid.UUID       `gorm:"type:uuid;primaryKey" json:"id"`
-	AuthorID  uuid.UUID       `gorm:"type:uuid;not null" json:"author_id"`
-	Title     string          `gorm:"not null;default:'Untitled Document'" json:"title"`
-	Content   json.RawMessage `gorm:"type:jsonb;not null;default:'[]'" json:"content"`
-	CreatedAt time.Time       `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+	ID          uuid.UUID       `gorm:"type:uuid;primaryKey" json:"id"`
+	AuthorID    uuid.UUID       `gorm:"type:uuid;not null" json:"author_id"`
+	WorkspaceID *uuid.UUID      `gorm:"type:uuid" json:"workspace_id"`
+	Title       string          `gorm:"not null;default:'Untitled Document'" json:"title"`
+	Description string          `gorm:"not null;default:''" json:"description"`
+	Content     json.RawMessage `gorm:"type:jsonb;not null;default:'[]'" json:"content"`
+	Revision    int64           `gorm:"not null;default:0" json:"revision"`
+	// set on copies, pointing at the document and revision they were made from
+	ForkedFromID       *uuid.UUID `gorm:"type:uuid" json:"forked_from_id"`
+	ForkedFromRevision *int64     `json:"forked_from_revision"`
+	// who last changed the content, set by every edit that has a user
+	LastEditedBy *uuid.UUID `gorm:"type:uuid" json:"last_edited_by"`
+	LastEditedAt *time.Time `json:"last_edited_at"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// DocumentOp is one entry of a document's edit history. Applying Delta to the
+// content at Revision-1 gives the content at Revision; Inverse undoes it.
+type DocumentOp struct {
+	ID         uuid.UUID       `gorm:"type:uuid;primaryKey" json:"id"`
+	DocumentID uuid.UUID       `gorm:"type:uuid;not null" json:"document_id"`
+	UserID     *uuid.UUID      `gorm:"type:uuid" json:"user_id"`
+	Revision   int64           `gorm:"not null" json:"revision"`
+	Delta      json.RawMessage `gorm:"type:jsonb;not null" json:"delta"`
+	Inverse    json.RawMessage `gorm:"type:jsonb;not null" json:"-"`
+	Source     string          `gorm:"not null" json:"source"`
+	CreatedAt  time.Time       `gorm:"autoCreateTime" json:"created_at"`
 }
 
 type DocResponse struct {
-	ID         uuid.UUID       `json:"id"`
-	Author 	   Author			`json:"author"`
-	Title      string          `json:"title"`
-	Content    json.RawMessage `json:"content"`
-	CreatedAt  time.Time       `json:"created_at"`
-	UpdatedAt  time.Time       `json:"updated_at"`
+	ID           uuid.UUID       `json:"id"`
+	Author       Author          `json:"author"`
+	WorkspaceID  *uuid.UUID      `json:"workspace_id"`
+	Title        string          `json:"title"`
+	Description  string          `json:"description"`
+	Tags         []string        `json:"tags"`
+	Properties   []PropertyValue `json:"properties"`
+	Content      json.RawMessage `json:"content"`
+	Revision     int64           `json:"revision"`
+	ForkedFrom   *ForkOrigin     `json:"forked_from,omitempty"`
+	LastEditedBy *Author         `json:"last_edited_by"`
+	LastEditedAt *time.Time      `json:"last_edited_at"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+type ForkOrigin struct {
+	ID       uuid.UUID `json:"id"`
+	Revision int64     `json:"revision"`
 }
 
 type Author struct {
-	ID   uuid.UUID       `json:"id"`
-	Name string          `json:"name"`
-}
\ No newline at end of file
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+// DocumentVisit is when a user last opened and last edited a document.
+type DocumentVisit struct {
+	UserID         uuid.UUID  `gorm:"type:uuid;primaryKey" json:"-"`
+	DocumentID     uuid.UUID  `gorm:"type:uuid;primaryKey" json:"document_id"`
+	LastOpenedAt   *time.Time `json:"last_opened_at"`
+	LastEditedAt   *time.Time `json:"last_edited_at"`
+	LastActivityAt time.Time  `json:"last_activity_at"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	PropertyText   = "text"
+	PropertyNumber = "number"
+	PropertyDate   = "date"
+	PropertySelect = "select"
+)
+
+type Tag struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	Name      string    `gorm:"not null" json:"name"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type DocumentTag struct {
+	DocumentID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	TagID      uuid.UUID `gorm:"type:uuid;primaryKey"`
+}
+
+// WorkspaceProperty is a custom field the documents of a workspace can fill
+// in. Options lists the allowed values of a select.
+type WorkspaceProperty struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	WorkspaceID uuid.UUID `gorm:"type:uuid;not null" json:"workspace_id"`
+	Name        string    `gorm:"not null" json:"name"`
+	Type        string    `gorm:"not null" json:"type" validate:"oneof=text number date select"`
+	Options     []string  `gorm:"serializer:json;type:jsonb;not null" json:"options"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type DocumentPropertyValue struct {
+	DocumentID uuid.UUID       `gorm:"type:uuid;primaryKey"`
+	PropertyID uuid.UUID       `gorm:"type:uuid;primaryKey"`
+	Value      json.RawMessage `gorm:"type:jsonb;not null"`
+}
+
+// PropertyValue is a property as shown on a document.
+type PropertyValue struct {
+	ID    uuid.UUID       `json:"id"`
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DocumentRoleViewer = "viewer"
+	DocumentRoleEditor = "editor"
+)
+
+const (
+	AccessRequestPending  = "pending"
+	AccessRequestApproved = "approved"
+	AccessRequestDenied   = "denied"
+)
+
+// DocumentPermission gives one user access to one document, on top of what
+// they get from its workspace.
+type DocumentPermission struct {
+	DocumentID uuid.UUID  `gorm:"type:uuid;primaryKey" json:"document_id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;primaryKey" json:"user_id"`
+	Role       string     `gorm:"not null" json:"role"`
+	GrantedBy  *uuid.UUID `gorm:"type:uuid" json:"granted_by"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type AccessRequest struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	DocumentID  uuid.UUID  `gorm:"type:uuid;not null" json:"document_id"`
+	RequesterID uuid.UUID  `gorm:"type:uuid;not null" json:"requester_id"`
+	Role        string     `gorm:"not null" json:"role"`
+	Message     string     `gorm:"not null" json:"message"`
+	Status      string     `gorm:"not null" json:"status"`
+	DecidedBy   *uuid.UUID `gorm:"type:uuid" json:"decided_by"`
+	DecidedAt   *time.Time `json:"decided_at"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
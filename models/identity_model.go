@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserIdentity links a user to an account at an external identity provider.
+// A user can have any number of them next to their password.
+type UserIdentity struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null" json:"user_id"`
+	Provider    string     `gorm:"not null" json:"provider"`
+	Subject     string     `gorm:"not null" json:"-"`
+	Email       *string    `json:"email"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DigestOff    = "off"
+	DigestHourly = "hourly"
+	DigestDaily  = "daily"
+)
+
+// Notification tells a user about something that happened to them. InApp
+// and Email are the user's preferences for its type when it was created.
+type Notification struct {
+	ID          uuid.UUID              `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID      uuid.UUID              `gorm:"type:uuid;not null" json:"-"`
+	Type        string                 `gorm:"not null" json:"type"`
+	ActorID     *uuid.UUID             `gorm:"type:uuid" json:"-"`
+	Actor       *Author                `gorm:"-" json:"actor"`
+	DocumentID  *uuid.UUID             `gorm:"type:uuid" json:"document_id"`
+	WorkspaceID *uuid.UUID             `gorm:"type:uuid" json:"workspace_id"`
+	Message     string                 `gorm:"not null" json:"message"`
+	Data        map[string]interface{} `gorm:"serializer:json;type:jsonb;not null" json:"data"`
+	InApp       bool                   `gorm:"not null" json:"-"`
+	Email       bool                   `gorm:"not null" json:"-"`
+	ReadAt      *time.Time             `json:"read_at"`
+	EmailedAt   *time.Time             `json:"-"`
+	CreatedAt   time.Time              `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// ChannelPreference says where notifications of one type go.
+type ChannelPreference struct {
+	InApp bool `json:"in_app"`
+	Email bool `json:"email"`
+}
+
+type NotificationPreferences struct {
+	UserID       uuid.UUID                    `gorm:"type:uuid;primaryKey" json:"-"`
+	Digest       string                       `gorm:"not null" json:"digest"`
+	Types        map[string]ChannelPreference `gorm:"serializer:json;type:jsonb;not null" json:"types"`
+	LastDigestAt *time.Time                   `json:"last_digest_at"`
+	UpdatedAt    time.Time                    `json:"updated_at"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PersonalAccessToken lets scripts call the API as a user, limited to its
+// scopes. Only the sha256 of the token is stored; Prefix is the start of it,
+// so the user can tell their tokens apart.
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null" json:"-"`
+	Name       string     `gorm:"not null" json:"name"`
+	TokenHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	Prefix     string     `gorm:"not null" json:"prefix"`
+	Scopes     []string   `gorm:"serializer:json;type:jsonb;not null" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP *string    `json:"last_used_ip"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
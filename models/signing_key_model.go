@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// SigningKey is a key pair used to sign our JWTs. A key is published in the
+// JWKS before ActivatesAt, signs until the next key activates and is kept
+// for verification until the tokens it signed have expired.
+type SigningKey struct {
+	Kid         string    `gorm:"primaryKey"`
+	Alg         string    `gorm:"not null"`
+	PrivateKey  string    `gorm:"not null"` // PKCS#8 PEM
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	ActivatesAt time.Time `gorm:"not null"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Template is a saved title and content new documents can start from. Personal
+// templates have no WorkspaceID; workspace templates are shared with its members.
+type Template struct {
+	ID          uuid.UUID       `gorm:"type:uuid;primaryKey" json:"id"`
+	OwnerID     uuid.UUID       `gorm:"type:uuid;not null" json:"owner_id"`
+	WorkspaceID *uuid.UUID      `gorm:"type:uuid" json:"workspace_id"`
+	Name        string          `gorm:"not null" json:"name"`
+	Title       string          `gorm:"not null" json:"title"`
+	Content     json.RawMessage `gorm:"type:jsonb;not null;default:'[]'" json:"content"`
+	CreatedAt   time.Time       `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+}
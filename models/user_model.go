@@ -6,12 +6,24 @@ import (
 	"github.com/google/uuid"
 )
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
-	ID           uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
-	Email        string    `gorm:"uniqueIndex;not null" json:"email" validate:"required,email"`
-	Name         string    `gorm:"not null" json:"name" validate:"required,min=2,max=30"`
-	PasswordHash *string   `json:"-" validate:"required"` // Always hidden in JSON
-	Provider     string    `gorm:"not null;default:'local'" json:"provider" validate:"oneof=local google"`
-	CreatedAt    time.Time `gorm:"createdAt" json:"created_at"`
-	UpdatedAt    time.Time `gorm:"updatedAt" json:"updated_at"`
+	ID              uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	Email           string     `gorm:"uniqueIndex;not null" json:"email" validate:"required,email"`
+	Name            string     `gorm:"not null" json:"name" validate:"required,min=2,max=30"`
+	PasswordHash    *string    `json:"-" validate:"required"`                                        // Always hidden in JSON
+	Provider        string     `gorm:"not null;default:'local'" json:"provider" validate:"required"` // how the account was created: "local" or an identity provider name
+	AvatarURL       *string    `json:"avatar_url"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	TOTPSecret      *string    `gorm:"column:totp_secret" json:"-"`
+	TOTPEnabledAt   *time.Time `gorm:"column:totp_enabled_at" json:"totp_enabled_at"`
+	TOTPLastStep    *int64     `gorm:"column:totp_last_step" json:"-"`
+	Role            string     `gorm:"not null;default:'user'" json:"role"`
+	DisabledAt      *time.Time `json:"disabled_at"` // disabled accounts can't sign in
+	CreatedAt       time.Time  `gorm:"createdAt" json:"created_at"`
+	UpdatedAt       time.Time  `gorm:"updatedAt" json:"updated_at"`
 }
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook posts document events to URL, signed with Secret. It watches one
+// document or a whole workspace.
+type Webhook struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	WorkspaceID *uuid.UUID `gorm:"type:uuid" json:"workspace_id"`
+	DocumentID  *uuid.UUID `gorm:"type:uuid" json:"document_id"`
+	URL         string     `gorm:"not null" json:"url"`
+	Secret      string     `gorm:"not null" json:"-"`
+	Events      []string   `gorm:"serializer:json;type:jsonb;not null" json:"events"`
+	Active      bool       `gorm:"not null" json:"active"`
+	CreatedBy   *uuid.UUID `gorm:"type:uuid" json:"created_by"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID       `gorm:"type:uuid;primaryKey" json:"id"`
+	WebhookID      uuid.UUID       `gorm:"type:uuid;not null" json:"webhook_id"`
+	Event          string          `gorm:"not null" json:"event"`
+	Payload        json.RawMessage `gorm:"type:jsonb;not null" json:"payload"`
+	Status         string          `gorm:"not null" json:"status"`
+	Attempts       int             `gorm:"not null" json:"attempts"`
+	NextAttemptAt  time.Time       `gorm:"not null" json:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code"`
+	LastError      *string         `json:"last_error"`
+	CreatedAt      time.Time       `gorm:"autoCreateTime" json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
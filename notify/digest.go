@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dipankarupd/text-editor/mailer"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+)
+
+// StartDigests emails users what they haven't read yet, hourly or daily as
+// they chose. Every instance can run it; each notification is only claimed
+// by one of them.
+func StartDigests() {
+	go func() {
+		for range time.Tick(5 * time.Minute) {
+			if err := sendDigests(); err != nil {
+				log.Printf("Sending notification digests failed: %v", err)
+			}
+		}
+	}()
+}
+
+func sendDigests() error {
+	// a digest is due a period after the last one, or after the oldest
+	// pending notification for someone who never got one
+	var due []uuid.UUID
+	err := db.Raw(`
+		SELECT n.user_id
+		FROM notifications n
+		JOIN users u ON u.id = n.user_id
+		LEFT JOIN notification_preferences p ON p.user_id = n.user_id
+		WHERE n.email AND n.emailed_at IS NULL AND n.read_at IS NULL
+			AND u.email_verified_at IS NOT NULL AND u.disabled_at IS NULL
+			AND coalesce(p.digest, ?) <> ?
+		GROUP BY n.user_id, p.digest, p.last_digest_at
+		HAVING coalesce(p.last_digest_at, min(n.created_at)) <=
+			now() - CASE coalesce(p.digest, ?) WHEN ? THEN interval '1 hour' ELSE interval '1 day' END
+	`, models.DigestDaily, models.DigestOff, models.DigestDaily, models.DigestHourly).Scan(&due).Error
+	if err != nil {
+		return err
+	}
+	for _, userID := range due {
+		if err := sendDigest(userID); err != nil {
+			log.Printf("Failed to send notification digest to %s: %v", userID, err)
+		}
+	}
+	return nil
+}
+
+func sendDigest(userID uuid.UUID) error {
+	var user models.User
+	if err := db.Select("id", "name", "email").First(&user, "id = ?", userID).Error; err != nil {
+		return err
+	}
+
+	var pending []models.Notification
+	err := db.Model(&pending).
+		Clauses(clause.Returning{}).
+		Where("user_id = ? AND email AND emailed_at IS NULL AND read_at IS NULL", userID).
+		Update("emailed_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].CreatedAt.Before(pending[j].CreatedAt) })
+
+	var lines strings.Builder
+	for _, n := range pending {
+		fmt.Fprintf(&lines, "- %s (%s)\n", n.Message, n.CreatedAt.UTC().Format("Jan 2, 15:04 MST"))
+	}
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	subject := "You have 1 unread notification"
+	if len(pending) > 1 {
+		subject = fmt.Sprintf("You have %d unread notifications", len(pending))
+	}
+
+	sendCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err = mailer.Send(sendCtx, mailer.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body: fmt.Sprintf("Hi %s,\n\nHere's what you missed:\n\n%s\nSee them all at %s/notifications\n\nYou can change how often you get this email in your notification settings.\n",
+			user.Name, lines.String(), strings.TrimSuffix(base, "/")),
+	})
+	if err != nil {
+		// give them back for the next round
+		ids := make([]uuid.UUID, len(pending))
+		for i, n := range pending {
+			ids[i] = n.ID
+		}
+		db.Model(&models.Notification{}).Where("id IN ?", ids).Update("emailed_at", nil)
+		return err
+	}
+	return db.Exec(`
+		INSERT INTO notification_preferences (user_id, digest, last_digest_at)
+		VALUES (?, ?, now())
+		ON CONFLICT (user_id) DO UPDATE SET last_digest_at = EXCLUDED.last_digest_at
+	`, userID, models.DigestDaily).Error
+}
@@ -0,0 +1,192 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/ws"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Types of notifications; users can turn each one on or off per channel.
+const (
+	AddedToWorkspace     = "workspace.added"
+	WorkspaceRoleChanged = "workspace.role_changed"
+	RemovedFromWorkspace = "workspace.removed"
+	DocumentTransferred  = "document.transferred"
+	AccessRequested      = "access_request.created"
+	AccessApproved       = "access_request.approved"
+	AccessDenied         = "access_request.denied"
+)
+
+var Types = []string{
+	AddedToWorkspace, WorkspaceRoleChanged, RemovedFromWorkspace, DocumentTransferred,
+	AccessRequested, AccessApproved, AccessDenied,
+}
+
+func ValidType(t string) bool {
+	for _, known := range Types {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+var db *gorm.DB
+
+func InitDb(database *gorm.DB) {
+	db = database
+}
+
+type Event struct {
+	Type        string
+	UserID      uuid.UUID  // who it's for
+	ActorID     *uuid.UUID // the signed in user when not set
+	DocumentID  *uuid.UUID
+	WorkspaceID *uuid.UUID
+	Data        map[string]interface{}
+}
+
+// Preferences returns the user's notification settings, or the defaults
+// when they never changed them.
+func Preferences(userID uuid.UUID) (*models.NotificationPreferences, error) {
+	var prefs models.NotificationPreferences
+	err := db.First(&prefs, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.NotificationPreferences{
+			UserID: userID,
+			Digest: models.DigestDaily,
+			Types:  map[string]models.ChannelPreference{},
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if prefs.Types == nil {
+		prefs.Types = map[string]models.ChannelPreference{}
+	}
+	return &prefs, nil
+}
+
+// Channel is where notifications of the type go for these preferences;
+// everywhere unless turned off.
+func Channel(prefs *models.NotificationPreferences, t string) models.ChannelPreference {
+	if channel, ok := prefs.Types[t]; ok {
+		return channel
+	}
+	return models.ChannelPreference{InApp: true, Email: true}
+}
+
+// Send stores the notification and pushes it to the user's open channels.
+// People aren't told about their own actions. Like the audit log, a failure
+// is logged and doesn't fail the request that caused it.
+func Send(ctx *gin.Context, event Event) {
+	if event.ActorID == nil {
+		if id, ok := ctx.Value("userid").(uuid.UUID); ok {
+			event.ActorID = &id
+		}
+	}
+	if event.ActorID != nil && *event.ActorID == event.UserID {
+		return
+	}
+	prefs, err := Preferences(event.UserID)
+	if err != nil {
+		log.Printf("Failed to load notification preferences of %s: %v", event.UserID, err)
+		return
+	}
+	channel := Channel(prefs, event.Type)
+	if !channel.InApp && !channel.Email {
+		return
+	}
+
+	data := event.Data
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	n := models.Notification{
+		ID:          uuid.New(),
+		UserID:      event.UserID,
+		Type:        event.Type,
+		ActorID:     event.ActorID,
+		DocumentID:  event.DocumentID,
+		WorkspaceID: event.WorkspaceID,
+		Data:        data,
+		InApp:       channel.InApp,
+		Email:       channel.Email,
+	}
+	actorName := "Someone"
+	if event.ActorID != nil {
+		var actor models.User
+		if err := db.Select("id", "name").First(&actor, "id = ?", *event.ActorID).Error; err == nil {
+			actorName = actor.Name
+			n.Actor = &models.Author{ID: actor.ID, Name: actor.Name}
+		}
+	}
+	n.Message = message(event.Type, actorName, data)
+
+	if err := db.Create(&n).Error; err != nil {
+		log.Printf("Failed to store %s notification for %s: %v", event.Type, event.UserID, err)
+		return
+	}
+	if !n.InApp {
+		return
+	}
+	unread, err := UnreadCount(event.UserID)
+	if err != nil {
+		log.Printf("Failed to count notifications of %s: %v", event.UserID, err)
+		return
+	}
+	ws.PushToUser(event.UserID, map[string]interface{}{
+		"event":        "notification",
+		"notification": n,
+		"unread":       unread,
+	})
+}
+
+// UnreadCount is the number of unread notifications shown in the app.
+func UnreadCount(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := db.Model(&models.Notification{}).
+		Where("user_id = ? AND in_app AND read_at IS NULL", userID).
+		Count(&count).Error
+	return count, err
+}
+
+// PushUnread tells the user's other tabs the unread count changed, after
+// notifications were read.
+func PushUnread(userID uuid.UUID) {
+	unread, err := UnreadCount(userID)
+	if err != nil {
+		log.Printf("Failed to count notifications of %s: %v", userID, err)
+		return
+	}
+	ws.PushToUser(userID, map[string]interface{}{"event": "notifications_read", "unread": unread})
+}
+
+func message(t, actor string, data map[string]interface{}) string {
+	switch t {
+	case AddedToWorkspace:
+		return fmt.Sprintf("%s added you to the workspace %q as %v", actor, data["workspace_name"], data["role"])
+	case WorkspaceRoleChanged:
+		return fmt.Sprintf("%s made you %v in the workspace %q", actor, data["role"], data["workspace_name"])
+	case RemovedFromWorkspace:
+		return fmt.Sprintf("%s removed you from the workspace %q", actor, data["workspace_name"])
+	case DocumentTransferred:
+		if count, ok := data["count"]; ok {
+			return fmt.Sprintf("%s transferred %v documents to you", actor, count)
+		}
+		return fmt.Sprintf("%s transferred %q to you", actor, data["title"])
+	case AccessRequested:
+		return fmt.Sprintf("%s asked for %v access to %q", actor, data["role"], data["title"])
+	case AccessApproved:
+		return fmt.Sprintf("%s gave you %v access to %q", actor, data["role"], data["title"])
+	case AccessDenied:
+		return fmt.Sprintf("%s declined your request for access to %q", actor, data["title"])
+	}
+	return actor + " did something"
+}
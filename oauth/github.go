@@ -0,0 +1,106 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// GitHubProvider signs in with GitHub, which speaks plain OAuth2 rather than
+// OpenID Connect, so the identity comes from its REST API.
+type GitHubProvider struct {
+	cfg ProviderConfig
+}
+
+func NewGitHubProvider(cfg ProviderConfig) *GitHubProvider {
+	if cfg.AuthURL == "" {
+		cfg.AuthURL = "https://github.com/login/oauth/authorize"
+	}
+	if cfg.TokenURL == "" {
+		cfg.TokenURL = "https://github.com/login/oauth/access_token"
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = "https://api.github.com"
+	}
+	cfg.APIURL = strings.TrimSuffix(cfg.APIURL, "/")
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"read:user", "user:email"}
+	}
+	return &GitHubProvider{cfg: cfg}
+}
+
+func (p *GitHubProvider) Name() string { return p.cfg.Name }
+
+func (p *GitHubProvider) AuthCodeURL(_ context.Context, state, codeChallenge, _ string) (string, error) {
+	q := url.Values{
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	return withQuery(p.cfg.AuthURL, q), nil
+}
+
+func (p *GitHubProvider) Exchange(ctx context.Context, code, codeVerifier, _ string) (*Identity, error) {
+	tokens, err := exchangeCode(ctx, p.cfg.TokenURL, p.cfg, code, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.AccessToken == "" {
+		return nil, errors.New("token response has no access_token")
+	}
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := p.get(ctx, "/user", tokens.AccessToken, &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, errors.New("github: missing user id")
+	}
+
+	// the profile email may be hidden or unverified; use the primary
+	// address from the emails endpoint instead
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.get(ctx, "/user/emails", tokens.AccessToken, &emails); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Provider: p.cfg.Name,
+		Subject:  strconv.FormatInt(user.ID, 10),
+		Name:     user.Name,
+	}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email = e.Email
+			identity.EmailVerified = e.Verified
+		}
+	}
+	return identity, nil
+}
+
+func (p *GitHubProvider) get(ctx context.Context, path, accessToken string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.APIURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	return doJSON(req, out)
+}
@@ -0,0 +1,132 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrInvalidIDToken    = errors.New("invalid id token")
+	ErrEmailNotVerified  = errors.New("email address is not verified")
+	ErrProviderNotConfig = errors.New("provider is not configured")
+)
+
+// audience accepts both the single string and the list form of "aud".
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// boolish accepts true and "true"; some providers send email_verified as a string.
+type boolish bool
+
+func (b *boolish) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// IDTokenClaims are the OpenID Connect claims we use.
+type IDTokenClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	ExpiresAt     int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce,omitempty"`
+	Email         string   `json:"email"`
+	EmailVerified boolish  `json:"email_verified"`
+	Name          string   `json:"name"`
+	Picture       string   `json:"picture"`
+}
+
+// Valid only checks the time based claims; issuer and audience are checked by
+// the verifier that knows what to expect.
+func (c *IDTokenClaims) Valid() error {
+	now := time.Now().Unix()
+	if c.ExpiresAt == 0 || now > c.ExpiresAt+clockSkew {
+		return errors.New("token expired")
+	}
+	if c.IssuedAt > now+clockSkew {
+		return errors.New("token used before issued")
+	}
+	return nil
+}
+
+const clockSkew = 60
+
+// IDTokenVerifier checks ID tokens from one OpenID provider: the signature
+// against the provider's keys, then issuer, audience and expiry.
+type IDTokenVerifier struct {
+	Issuers   []string
+	Audiences []string
+	Keys      KeySource
+}
+
+func (v *IDTokenVerifier) Verify(ctx context.Context, rawToken string) (*IDTokenClaims, error) {
+	if v == nil || v.Keys == nil || len(v.Audiences) == 0 {
+		return nil, ErrProviderNotConfig
+	}
+
+	claims := &IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %s", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		return v.Keys.Key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if !contains(v.Issuers, claims.Issuer) {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	}
+	matched := false
+	for _, aud := range claims.Audience {
+		if contains(v.Audiences, aud) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidIDToken)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	return claims, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider talks to any OpenID Connect provider. Endpoints come from the
+// issuer's discovery document, fetched on first use and refreshed daily.
+type OIDCProvider struct {
+	cfg ProviderConfig
+
+	mu           sync.Mutex
+	discovery    *discoveryDocument
+	discoveredAt time.Time
+	verifier     *IDTokenVerifier
+}
+
+const discoveryTTL = 24 * time.Hour
+
+func NewOIDCProvider(cfg ProviderConfig) *OIDCProvider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &OIDCProvider{cfg: cfg}
+}
+
+func (p *OIDCProvider) Name() string { return p.cfg.Name }
+
+func (p *OIDCProvider) discover(ctx context.Context) (*discoveryDocument, *IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveredAt) < discoveryTTL {
+		return p.discovery, p.verifier, nil
+	}
+	if p.cfg.Issuer == "" {
+		return nil, nil, ErrProviderNotConfig
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var doc discoveryDocument
+	if err := doJSON(req, &doc); err != nil {
+		if p.discovery != nil {
+			return p.discovery, p.verifier, nil
+		}
+		return nil, nil, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.cfg.Issuer {
+		return nil, nil, fmt.Errorf("discovery: issuer %q does not match %q", doc.Issuer, p.cfg.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, nil, errors.New("discovery: missing endpoints")
+	}
+
+	p.discovery = &doc
+	p.discoveredAt = time.Now()
+	p.verifier = &IDTokenVerifier{
+		Issuers:   []string{doc.Issuer},
+		Audiences: []string{p.cfg.ClientID},
+		Keys:      NewRemoteKeySource(doc.JWKSURI),
+	}
+	return p.discovery, p.verifier, nil
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, codeChallenge, nonce string) (string, error) {
+	doc, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	return withQuery(doc.AuthorizationEndpoint, q), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	doc, verifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := exchangeCode(ctx, doc.TokenEndpoint, p.cfg, code, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	claims, err := verifier.Verify(ctx, tokens.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	return &Identity{
+		Provider:      p.cfg.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
+
+func withQuery(endpoint string, q url.Values) string {
+	sep := "?"
+	if strings.Contains(endpoint, "?") {
+		sep = "&"
+	}
+	return endpoint + sep + q.Encode()
+}
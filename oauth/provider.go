@@ -0,0 +1,201 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Identity is who the provider says signed in.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider runs the authorization code flow with PKCE against one identity
+// provider.
+type Provider interface {
+	Name() string
+	// AuthCodeURL is where the user is sent to sign in.
+	AuthCodeURL(ctx context.Context, state, codeChallenge, nonce string) (string, error)
+	// Exchange trades the code returned to the redirect URL for the identity.
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error)
+}
+
+// ProviderConfig is read from OAUTH_<NAME>_* environment variables.
+type ProviderConfig struct {
+	Name         string
+	Type         string // "oidc" or "github"
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// endpoint overrides, mainly to point GitHub at a mock server
+	AuthURL  string
+	TokenURL string
+	APIURL   string
+}
+
+var providers = map[string]Provider{}
+
+// Register adds a provider, replacing any with the same name.
+func Register(p Provider) {
+	providers[p.Name()] = p
+}
+
+func Lookup(name string) (Provider, bool) {
+	p, ok := providers[name]
+	return p, ok
+}
+
+// ProviderNames lists the configured providers.
+func ProviderNames() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InitProviders registers the providers listed in OAUTH_PROVIDERS, e.g.
+// "corp,github", each configured by its own OAUTH_<NAME>_* variables.
+func InitProviders() {
+	for _, name := range strings.Split(os.Getenv("OAUTH_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		cfg := providerConfigFromEnv(name)
+
+		var p Provider
+		switch cfg.Type {
+		case "oidc":
+			p = NewOIDCProvider(cfg)
+		case "github":
+			p = NewGitHubProvider(cfg)
+		default:
+			log.Printf("⚠️ Unknown type %q for OAuth provider %s, skipping", cfg.Type, name)
+			continue
+		}
+		if cfg.ClientID == "" || cfg.RedirectURL == "" {
+			log.Printf("⚠️ OAuth provider %s needs a client id and redirect url, skipping", name)
+			continue
+		}
+		Register(p)
+	}
+}
+
+func providerConfigFromEnv(name string) ProviderConfig {
+	prefix := "OAUTH_" + strings.ToUpper(name) + "_"
+	cfg := ProviderConfig{
+		Name:         name,
+		Type:         os.Getenv(prefix + "TYPE"),
+		Issuer:       strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+		ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+		ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		AuthURL:      os.Getenv(prefix + "AUTH_URL"),
+		TokenURL:     os.Getenv(prefix + "TOKEN_URL"),
+		APIURL:       os.Getenv(prefix + "API_URL"),
+	}
+	if cfg.Type == "" {
+		cfg.Type = "oidc"
+		if name == "github" {
+			cfg.Type = "github"
+		}
+	}
+	if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+		cfg.Scopes = strings.Fields(strings.ReplaceAll(scopes, ",", " "))
+	}
+	return cfg
+}
+
+// NewPKCE returns a code verifier and its S256 challenge.
+func NewPKCE() (verifier string, challenge string) {
+	verifier = RandomToken(32)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// RandomToken returns n random bytes, base64url encoded.
+func RandomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+	Error       string `json:"error"`
+	ErrorDesc   string `json:"error_description"`
+}
+
+// exchangeCode posts the authorization code to the token endpoint.
+func exchangeCode(ctx context.Context, tokenURL string, cfg ProviderConfig, code, codeVerifier string) (*tokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {cfg.RedirectURL},
+		"client_id":     {cfg.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	if cfg.ClientSecret != "" {
+		form.Set("client_secret", cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var tokens tokenResponse
+	if err := doJSON(req, &tokens); err != nil {
+		return nil, fmt.Errorf("token exchange: %w", err)
+	}
+	if tokens.Error != "" {
+		return nil, fmt.Errorf("token exchange: %s %s", tokens.Error, tokens.ErrorDesc)
+	}
+	return &tokens, nil
+}
+
+func doJSON(req *http.Request, out interface{}) error {
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("%s: status %d", req.URL, res.StatusCode)
+	}
+	return json.Unmarshal(body, out)
+}
@@ -0,0 +1,475 @@
+package ot
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"unicode/utf16"
+)
+
+// Op is a single Quill delta operation. Exactly one of Insert, Delete or
+// Retain is set. Insert is either a string or an embed object.
+type Op struct {
+	Insert     interface{}            `json:"insert,omitempty"`
+	Delete     int                    `json:"delete,omitempty"`
+	Retain     int                    `json:"retain,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Delta is a list of ops, either describing a whole document (inserts only)
+// or a change to one.
+type Delta struct {
+	Ops []Op `json:"ops"`
+}
+
+var ErrInvalidDelta = errors.New("invalid delta")
+
+// Parse accepts both the stored document format (a bare array of ops) and
+// the change format sent by clients ({"ops": [...]}).
+func Parse(raw json.RawMessage) (Delta, error) {
+	var d Delta
+	if len(raw) == 0 || string(raw) == "null" {
+		return d, nil
+	}
+
+	var ops []Op
+	if err := json.Unmarshal(raw, &ops); err == nil {
+		d.Ops = ops
+	} else if err := json.Unmarshal(raw, &d); err != nil {
+		return Delta{}, ErrInvalidDelta
+	}
+
+	if err := d.Validate(); err != nil {
+		return Delta{}, err
+	}
+	return d, nil
+}
+
+// Validate checks that every op has exactly one action with a positive length.
+func (d Delta) Validate() error {
+	for _, op := range d.Ops {
+		actions := 0
+		if op.Insert != nil {
+			actions++
+			switch v := op.Insert.(type) {
+			case string:
+				if v == "" {
+					return ErrInvalidDelta
+				}
+			case map[string]interface{}:
+			default:
+				return ErrInvalidDelta
+			}
+		}
+		if op.Delete != 0 {
+			actions++
+			if op.Delete < 0 {
+				return ErrInvalidDelta
+			}
+		}
+		if op.Retain != 0 {
+			actions++
+			if op.Retain < 0 {
+				return ErrInvalidDelta
+			}
+		}
+		if actions != 1 {
+			return ErrInvalidDelta
+		}
+	}
+	return nil
+}
+
+// Raw returns the document format stored in documents.content.
+func (d Delta) Raw() json.RawMessage {
+	ops := d.Ops
+	if ops == nil {
+		ops = []Op{}
+	}
+	raw, _ := json.Marshal(ops)
+	return raw
+}
+
+// JSON returns the change format broadcast to clients.
+func (d Delta) JSON() json.RawMessage {
+	if d.Ops == nil {
+		d.Ops = []Op{}
+	}
+	raw, _ := json.Marshal(d)
+	return raw
+}
+
+func (op Op) isInsert() bool { return op.Insert != nil }
+func (op Op) isDelete() bool { return op.Delete > 0 }
+func (op Op) isRetain() bool { return op.Retain > 0 }
+
+// Length of an op in UTF-16 code units, matching Quill on the client.
+// Embeds count as one.
+func (op Op) Length() int {
+	switch {
+	case op.isDelete():
+		return op.Delete
+	case op.isRetain():
+		return op.Retain
+	case op.isInsert():
+		if s, ok := op.Insert.(string); ok {
+			return len(utf16.Encode([]rune(s)))
+		}
+		return 1
+	}
+	return 0
+}
+
+// Length is the document length for a document delta.
+func (d Delta) Length() int {
+	n := 0
+	for _, op := range d.Ops {
+		if op.isInsert() {
+			n += op.Length()
+		}
+	}
+	return n
+}
+
+// BaseLength is the minimum document length the change can be applied to.
+func (d Delta) BaseLength() int {
+	n := 0
+	for _, op := range d.Ops {
+		if op.isRetain() || op.isDelete() {
+			n += op.Length()
+		}
+	}
+	return n
+}
+
+// IsDocument reports whether the delta only contains inserts.
+func (d Delta) IsDocument() bool {
+	for _, op := range d.Ops {
+		if !op.isInsert() {
+			return false
+		}
+	}
+	return true
+}
+
+// CharsInserted and CharsDeleted summarise a change.
+func (d Delta) CharsInserted() int {
+	n := 0
+	for _, op := range d.Ops {
+		if op.isInsert() {
+			n += op.Length()
+		}
+	}
+	return n
+}
+
+func (d Delta) CharsDeleted() int {
+	n := 0
+	for _, op := range d.Ops {
+		if op.isDelete() {
+			n += op.Delete
+		}
+	}
+	return n
+}
+
+func (d *Delta) Insert(value interface{}, attributes map[string]interface{}) *Delta {
+	if s, ok := value.(string); ok && s == "" {
+		return d
+	}
+	return d.push(Op{Insert: value, Attributes: attributes})
+}
+
+func (d *Delta) DeleteN(n int) *Delta {
+	if n <= 0 {
+		return d
+	}
+	return d.push(Op{Delete: n})
+}
+
+func (d *Delta) RetainN(n int, attributes map[string]interface{}) *Delta {
+	if n <= 0 {
+		return d
+	}
+	return d.push(Op{Retain: n, Attributes: attributes})
+}
+
+// push appends an op, merging it into the previous one where possible and
+// keeping inserts ahead of deletes at the same position.
+func (d *Delta) push(op Op) *Delta {
+	if len(op.Attributes) == 0 {
+		op.Attributes = nil
+	}
+	index := len(d.Ops)
+	if index > 0 {
+		last := d.Ops[index-1]
+		if op.isDelete() && last.isDelete() {
+			d.Ops[index-1].Delete += op.Delete
+			return d
+		}
+		if last.isDelete() && op.isInsert() {
+			index--
+			if index == 0 {
+				d.Ops = append([]Op{op}, d.Ops...)
+				return d
+			}
+			last = d.Ops[index-1]
+		}
+		if reflect.DeepEqual(op.Attributes, last.Attributes) {
+			ls, lok := last.Insert.(string)
+			ns, nok := op.Insert.(string)
+			if lok && nok {
+				d.Ops[index-1] = Op{Insert: ls + ns, Attributes: op.Attributes}
+				return d
+			}
+			if op.isRetain() && last.isRetain() {
+				d.Ops[index-1] = Op{Retain: last.Retain + op.Retain, Attributes: op.Attributes}
+				return d
+			}
+		}
+	}
+	if index == len(d.Ops) {
+		d.Ops = append(d.Ops, op)
+	} else {
+		d.Ops = append(d.Ops[:index], append([]Op{op}, d.Ops[index:]...)...)
+	}
+	return d
+}
+
+// chop drops a trailing plain retain, which is a no-op.
+func (d *Delta) chop() *Delta {
+	if n := len(d.Ops); n > 0 {
+		last := d.Ops[n-1]
+		if last.isRetain() && last.Attributes == nil {
+			d.Ops = d.Ops[:n-1]
+		}
+	}
+	return d
+}
+
+// Slice returns the part of a document delta between start and end.
+func (d Delta) Slice(start, end int) Delta {
+	var out Delta
+	it := newIterator(d.Ops)
+	index := 0
+	for index < end && it.hasNext() {
+		var next Op
+		if index < start {
+			next = it.next(start - index)
+		} else {
+			next = it.next(end - index)
+			out.push(next)
+		}
+		index += next.Length()
+	}
+	return out
+}
+
+// Compose returns a delta equivalent to applying d and then other.
+func (d Delta) Compose(other Delta) Delta {
+	a := newIterator(d.Ops)
+	b := newIterator(other.Ops)
+	var out Delta
+	for a.hasNext() || b.hasNext() {
+		if b.peekType() == typeInsert {
+			out.push(b.next(infinity))
+		} else if a.peekType() == typeDelete {
+			out.push(a.next(infinity))
+		} else {
+			length := min(a.peekLength(), b.peekLength())
+			aOp := a.next(length)
+			bOp := b.next(length)
+			if bOp.isRetain() {
+				op := Op{}
+				if aOp.isRetain() {
+					op.Retain = length
+				} else {
+					op.Insert = aOp.Insert
+				}
+				op.Attributes = composeAttributes(aOp.Attributes, bOp.Attributes, aOp.isRetain())
+				out.push(op)
+			} else if bOp.isDelete() && aOp.isRetain() {
+				out.push(bOp)
+			}
+		}
+	}
+	return *out.chop()
+}
+
+// Transform rebases other so it can be applied after d. When priority is
+// true d is treated as having happened first, so its inserts win ties.
+func (d Delta) Transform(other Delta, priority bool) Delta {
+	a := newIterator(d.Ops)
+	b := newIterator(other.Ops)
+	var out Delta
+	for a.hasNext() || b.hasNext() {
+		if a.peekType() == typeInsert && (priority || b.peekType() != typeInsert) {
+			out.RetainN(a.next(infinity).Length(), nil)
+		} else if b.peekType() == typeInsert {
+			out.push(b.next(infinity))
+		} else {
+			length := min(a.peekLength(), b.peekLength())
+			aOp := a.next(length)
+			bOp := b.next(length)
+			if aOp.isDelete() {
+				continue
+			} else if bOp.isDelete() {
+				out.push(bOp)
+			} else {
+				out.RetainN(length, transformAttributes(aOp.Attributes, bOp.Attributes, priority))
+			}
+		}
+	}
+	return *out.chop()
+}
+
+// Rebase moves change, made against the document before the concurrent
+// changes, to after them. The concurrent changes are transformed in place
+// to come after change, so the next change in a batch made on top of it can
+// be rebased over them too. The concurrent changes win insert ties, as they
+// happened first.
+func Rebase(change Delta, concurrent []Delta) Delta {
+	for i, earlier := range concurrent {
+		rebased := earlier.Transform(change, true)
+		concurrent[i] = change.Transform(earlier, false)
+		change = rebased
+	}
+	return change
+}
+
+// Invert returns the change that undoes d when applied to base composed with d.
+func (d Delta) Invert(base Delta) Delta {
+	var out Delta
+	baseIndex := 0
+	for _, op := range d.Ops {
+		switch {
+		case op.isInsert():
+			out.DeleteN(op.Length())
+		case op.isRetain() && op.Attributes == nil:
+			out.RetainN(op.Retain, nil)
+			baseIndex += op.Retain
+		default:
+			length := op.Length()
+			for _, baseOp := range base.Slice(baseIndex, baseIndex+length).Ops {
+				if op.isDelete() {
+					out.push(baseOp)
+				} else {
+					out.RetainN(baseOp.Length(), invertAttributes(op.Attributes, baseOp.Attributes))
+				}
+			}
+			baseIndex += length
+		}
+	}
+	return *out.chop()
+}
+
+// Diff returns a change turning document d into document other. It finds the
+// common prefix and suffix and replaces whatever lies between them.
+func (d Delta) Diff(other Delta) Delta {
+	a := units(d)
+	b := units(other)
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && sameUnit(a[prefix], b[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		sameUnit(a[len(a)-suffix-1], b[len(b)-suffix-1]) {
+		suffix++
+	}
+
+	var out Delta
+	out.RetainN(unitsLength(a[:prefix]), nil)
+	for _, op := range b[prefix : len(b)-suffix] {
+		out.push(op)
+	}
+	out.DeleteN(unitsLength(a[prefix : len(a)-suffix]))
+	return *out.chop()
+}
+
+// units splits a document into one op per character or embed. Characters
+// outside the BMP stay whole: split into UTF-16 halves, different emoji
+// would look the same.
+func units(d Delta) []Op {
+	out := make([]Op, 0, d.Length())
+	for _, op := range d.Ops {
+		if !op.isInsert() {
+			continue
+		}
+		s, ok := op.Insert.(string)
+		if !ok {
+			out = append(out, op)
+			continue
+		}
+		for _, r := range s {
+			out = append(out, Op{Insert: string(r), Attributes: op.Attributes})
+		}
+	}
+	return out
+}
+
+// unitsLength is the length of units in UTF-16 code units.
+func unitsLength(units []Op) int {
+	n := 0
+	for _, op := range units {
+		n += op.Length()
+	}
+	return n
+}
+
+func sameUnit(a, b Op) bool {
+	return reflect.DeepEqual(a.Insert, b.Insert) && reflect.DeepEqual(a.Attributes, b.Attributes)
+}
+
+func composeAttributes(a, b map[string]interface{}, keepNull bool) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range b {
+		if v != nil || keepNull {
+			out[k] = v
+		}
+	}
+	for k, v := range a {
+		if _, ok := b[k]; !ok {
+			out[k] = v
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func transformAttributes(a, b map[string]interface{}, priority bool) map[string]interface{} {
+	if a == nil || !priority {
+		return b
+	}
+	out := map[string]interface{}{}
+	for k, v := range b {
+		if _, ok := a[k]; !ok {
+			out[k] = v
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func invertAttributes(attrs, base map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range base {
+		if av, ok := attrs[k]; ok && !reflect.DeepEqual(av, v) {
+			out[k] = v
+		}
+	}
+	for k := range attrs {
+		if _, ok := base[k]; !ok {
+			out[k] = nil
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
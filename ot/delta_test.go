@@ -0,0 +1,217 @@
+package ot
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, raw string) Delta {
+	t.Helper()
+	d, err := Parse(json.RawMessage(raw))
+	if err != nil {
+		t.Fatalf("parse %s: %v", raw, err)
+	}
+	return d
+}
+
+// text renders a document delta, embeds as "*".
+func text(d Delta) string {
+	var b strings.Builder
+	for _, op := range d.Ops {
+		if s, ok := op.Insert.(string); ok {
+			b.WriteString(s)
+		} else {
+			b.WriteString("*")
+		}
+	}
+	return b.String()
+}
+
+func assertDelta(t *testing.T, got Delta, want string) {
+	t.Helper()
+	if string(got.JSON()) != string(parse(t, want).JSON()) {
+		t.Errorf("got %s, want %s", got.JSON(), parse(t, want).JSON())
+	}
+}
+
+func TestLengthCountsUTF16Units(t *testing.T) {
+	tests := []struct {
+		insert string
+		want   int
+	}{
+		{"abc", 3},
+		{"é", 1},
+		{"😀", 2},
+		{"a😀b", 4},
+		{"👍🏽", 4},
+	}
+	for _, tt := range tests {
+		if got := (Op{Insert: tt.insert}).Length(); got != tt.want {
+			t.Errorf("Length(%q) = %d, want %d", tt.insert, got, tt.want)
+		}
+	}
+}
+
+func TestCompose(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"insert into document", `[{"insert":"Hello"}]`, `{"ops":[{"retain":5},{"insert":" world"}]}`, `[{"insert":"Hello world"}]`},
+		{"delete", `[{"insert":"Hello world"}]`, `{"ops":[{"retain":5},{"delete":6}]}`, `[{"insert":"Hello"}]`},
+		{"format", `[{"insert":"Hello"}]`, `{"ops":[{"retain":2,"attributes":{"bold":true}}]}`, `[{"insert":"He","attributes":{"bold":true}},{"insert":"llo"}]`},
+		{"remove format", `[{"insert":"Hi","attributes":{"bold":true}}]`, `{"ops":[{"retain":2,"attributes":{"bold":null}}]}`, `[{"insert":"Hi"}]`},
+		{"insert after emoji", `[{"insert":"😀b"}]`, `{"ops":[{"retain":2},{"insert":"a"}]}`, `[{"insert":"😀ab"}]`},
+		{"delete emoji", `[{"insert":"a😀b"}]`, `{"ops":[{"retain":1},{"delete":2}]}`, `[{"insert":"ab"}]`},
+		{"changes", `{"ops":[{"insert":"a"}]}`, `{"ops":[{"insert":"b"}]}`, `{"ops":[{"insert":"ba"}]}`},
+		{"delete then insert", `{"ops":[{"retain":3},{"delete":1}]}`, `{"ops":[{"retain":3},{"insert":"X"}]}`, `{"ops":[{"retain":3},{"insert":"X"},{"delete":1}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertDelta(t, parse(t, tt.a).Compose(parse(t, tt.b)), tt.want)
+		})
+	}
+}
+
+func TestTransformConverges(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		a, b string
+		want string
+	}{
+		{"inserts at the same place", "abc", `{"ops":[{"retain":1},{"insert":"X"}]}`, `{"ops":[{"retain":1},{"insert":"Y"}]}`, "aXYbc"},
+		{"insert and delete", "abcdef", `{"ops":[{"retain":3},{"insert":"X"}]}`, `{"ops":[{"retain":1},{"delete":4}]}`, "aXf"},
+		{"overlapping deletes", "abcdef", `{"ops":[{"retain":1},{"delete":3}]}`, `{"ops":[{"retain":2},{"delete":3}]}`, "af"},
+		{"delete and format", "abcdef", `{"ops":[{"delete":2}]}`, `{"ops":[{"retain":1},{"retain":3,"attributes":{"bold":true}}]}`, "cdef"},
+		{"around an emoji", "a😀b", `{"ops":[{"retain":3},{"insert":"X"}]}`, `{"ops":[{"retain":1},{"delete":2}]}`, "aXb"},
+		{"embeds", "ab", `{"ops":[{"retain":1},{"insert":{"image":"x.png"}}]}`, `{"ops":[{"retain":2},{"insert":"c"}]}`, "a*bc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := parse(t, `[{"insert":`+mustJSON(tt.doc)+`}]`)
+			a, b := parse(t, tt.a), parse(t, tt.b)
+
+			// a happened first on one side, b on the other
+			bAfterA := a.Transform(b, true)
+			aAfterB := b.Transform(a, false)
+			left := doc.Compose(a).Compose(bAfterA)
+			right := doc.Compose(b).Compose(aAfterB)
+
+			if string(left.Raw()) != string(right.Raw()) {
+				t.Fatalf("diverged: a∘b' = %s, b∘a' = %s", left.Raw(), right.Raw())
+			}
+			if text(left) != tt.want {
+				t.Errorf("got %q, want %q", text(left), tt.want)
+			}
+			// composing the changes first gives the same document
+			if got := doc.Compose(a.Compose(bAfterA)); string(got.Raw()) != string(left.Raw()) {
+				t.Errorf("doc∘(a∘b') = %s, want %s", got.Raw(), left.Raw())
+			}
+		})
+	}
+}
+
+func TestInvertRoundTrip(t *testing.T) {
+	doc := parse(t, `[{"insert":"He"},{"insert":"llo","attributes":{"bold":true}},{"insert":" 😀 "},{"insert":{"image":"x.png"}},{"insert":"world\n"}]`)
+	changes := []string{
+		`{"ops":[{"retain":5},{"insert":"!"}]}`,
+		`{"ops":[{"retain":1},{"delete":5}]}`,
+		`{"ops":[{"retain":1},{"delete":7}]}`,
+		`{"ops":[{"retain":2},{"retain":4,"attributes":{"bold":null,"italic":true}}]}`,
+		`{"ops":[{"retain":5},{"retain":3,"attributes":{"underline":true}}]}`,
+		`{"ops":[{"retain":6},{"delete":2},{"insert":"🎉"}]}`,
+		`{"ops":[{"retain":9},{"delete":1}]}`,
+		`{"ops":[{"delete":16}]}`,
+	}
+	for _, raw := range changes {
+		change := parse(t, raw)
+		inverse := change.Invert(doc)
+		if got := doc.Compose(change).Compose(inverse); string(got.Raw()) != string(doc.Raw()) {
+			t.Errorf("%s: undoing gave %s, want %s", raw, got.Raw(), doc.Raw())
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct{ a, b string }{
+		{`[{"insert":"Hello world"}]`, `[{"insert":"Hello there world"}]`},
+		{`[{"insert":"abc"}]`, `[]`},
+		{`[]`, `[{"insert":"abc"}]`},
+		{`[{"insert":"a😀b"}]`, `[{"insert":"a😁b"}]`},
+		{`[{"insert":"😀😀"}]`, `[{"insert":"😀"}]`},
+		{`[{"insert":"x😀y"}]`, `[{"insert":"x🎉😀y"}]`},
+		{`[{"insert":"bold"}]`, `[{"insert":"bold","attributes":{"bold":true}}]`},
+	}
+	for _, tt := range tests {
+		a, b := parse(t, tt.a), parse(t, tt.b)
+		if got := a.Compose(a.Diff(b)); string(got.Raw()) != string(b.Raw()) {
+			t.Errorf("%s -> %s: got %s", tt.a, tt.b, got.Raw())
+		}
+	}
+}
+
+// Two clients edit at base revision 0 while the server moves on to revision
+// 2. Each client's batch is rebased over what it hasn't seen, and the result
+// has to be the same whichever order the server got them in.
+func TestRebaseConcurrentBases(t *testing.T) {
+	doc := parse(t, `[{"insert":"The quick fox\n"}]`)
+	server := []Delta{
+		parse(t, `{"ops":[{"retain":4},{"insert":"very "}]}`),   // The very quick fox
+		parse(t, `{"ops":[{"retain":15},{"insert":"brown "}]}`), // The very quick brown fox
+	}
+	// made against revision 0, the second on top of the first
+	batch := []Delta{
+		parse(t, `{"ops":[{"retain":13},{"insert":" jumps"}]}`), // The quick fox jumps
+		parse(t, `{"ops":[{"delete":4}]}`),                      // quick fox jumps
+	}
+
+	content := doc
+	for _, s := range server {
+		content = content.Compose(s)
+	}
+	concurrent := append([]Delta(nil), server...)
+	for _, change := range batch {
+		change = Rebase(change, concurrent)
+		if change.BaseLength() > content.Length() {
+			t.Fatalf("rebased change %s doesn't fit %q", change.JSON(), text(content))
+		}
+		content = content.Compose(change)
+	}
+
+	want := "very quick brown fox jumps\n"
+	if text(content) != want {
+		t.Fatalf("got %q, want %q", text(content), want)
+	}
+
+	// the other way round: the batch first, then the server's changes
+	// rebased over it
+	other := doc
+	for _, change := range batch {
+		other = other.Compose(change)
+	}
+	applied := append([]Delta(nil), batch...)
+	for _, s := range server {
+		other = other.Compose(Rebase(s, applied))
+	}
+	if text(other) != want {
+		t.Errorf("other order gave %q, want %q", text(other), want)
+	}
+}
+
+func TestRebaseDeletedText(t *testing.T) {
+	// both delete the same word; the second delete has nothing left to do
+	doc := parse(t, `[{"insert":"one two three"}]`)
+	first := parse(t, `{"ops":[{"retain":3},{"delete":4}]}`)
+	second := Rebase(parse(t, `{"ops":[{"retain":4},{"delete":4}]}`), []Delta{first})
+	if got := text(doc.Compose(first).Compose(second)); got != "onethree" {
+		t.Errorf("got %q, want %q", got, "onethree")
+	}
+}
+
+func mustJSON(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
@@ -0,0 +1,109 @@
+package ot
+
+import (
+	"math"
+	"unicode/utf16"
+)
+
+const infinity = math.MaxInt
+
+type opType int
+
+const (
+	typeRetain opType = iota
+	typeInsert
+	typeDelete
+)
+
+// iterator walks a list of ops, splitting them at arbitrary lengths.
+type iterator struct {
+	ops    []Op
+	index  int
+	offset int
+
+	// UTF-16 form of the current string insert, kept so that walking a long
+	// insert unit by unit stays linear.
+	encoded      []uint16
+	encodedIndex int
+}
+
+func newIterator(ops []Op) *iterator {
+	return &iterator{ops: ops}
+}
+
+func (it *iterator) hasNext() bool {
+	return it.peekLength() < infinity
+}
+
+func (it *iterator) peekLength() int {
+	if it.index < len(it.ops) {
+		return it.currentLength() - it.offset
+	}
+	return infinity
+}
+
+func (it *iterator) currentLength() int {
+	if _, ok := it.ops[it.index].Insert.(string); ok {
+		return len(it.current())
+	}
+	return it.ops[it.index].Length()
+}
+
+func (it *iterator) current() []uint16 {
+	if it.encoded == nil || it.encodedIndex != it.index {
+		s, _ := it.ops[it.index].Insert.(string)
+		it.encoded = utf16.Encode([]rune(s))
+		it.encodedIndex = it.index
+	}
+	return it.encoded
+}
+
+func (it *iterator) peekType() opType {
+	if it.index < len(it.ops) {
+		op := it.ops[it.index]
+		switch {
+		case op.isDelete():
+			return typeDelete
+		case op.isInsert():
+			return typeInsert
+		}
+	}
+	return typeRetain
+}
+
+// next returns up to length units of the current op and advances past them.
+// Once the ops are exhausted it returns an unbounded retain.
+func (it *iterator) next(length int) Op {
+	if it.index >= len(it.ops) {
+		return Op{Retain: infinity}
+	}
+
+	op := it.ops[it.index]
+	offset := it.offset
+	opLength := it.currentLength()
+	var encoded []uint16
+	if _, ok := op.Insert.(string); ok {
+		encoded = it.current()
+	}
+	if length >= opLength-offset {
+		length = opLength - offset
+		it.index++
+		it.offset = 0
+	} else {
+		it.offset += length
+	}
+
+	switch {
+	case op.isDelete():
+		return Op{Delete: length}
+	case op.isRetain():
+		return Op{Retain: length, Attributes: op.Attributes}
+	}
+	if s, ok := op.Insert.(string); ok {
+		if offset == 0 && length == opLength {
+			return Op{Insert: s, Attributes: op.Attributes}
+		}
+		return Op{Insert: string(utf16.Decode(encoded[offset : offset+length])), Attributes: op.Attributes}
+	}
+	return Op{Insert: op.Insert, Attributes: op.Attributes}
+}
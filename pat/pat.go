@@ -0,0 +1,108 @@
+package pat
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/utils"
+	"gorm.io/gorm"
+)
+
+// Prefix starts every personal access token, so they can't be mistaken for
+// a JWT and secret scanners can find leaked ones.
+const Prefix = "tep_"
+
+const (
+	ScopeDocumentsRead  = "documents:read"
+	ScopeDocumentsWrite = "documents:write"
+	ScopeUsersRead      = "users:read"
+)
+
+// Scopes is every scope a token can be given.
+var Scopes = []string{ScopeDocumentsRead, ScopeDocumentsWrite, ScopeUsersRead}
+
+// a scope also grants the ones listed here
+var implied = map[string][]string{
+	ScopeDocumentsWrite: {ScopeDocumentsRead},
+}
+
+var (
+	ErrInvalidToken = errors.New("invalid access token")
+	ErrExpiredToken = errors.New("access token expired")
+	ErrUserDisabled = errors.New("account disabled")
+)
+
+var db *gorm.DB
+
+func InitDb(database *gorm.DB) {
+	db = database
+}
+
+// IsToken tells a personal access token apart from a JWT.
+func IsToken(raw string) bool {
+	return strings.HasPrefix(raw, Prefix)
+}
+
+// Generate returns a new token in the clear.
+func Generate() string {
+	return Prefix + utils.RandomToken(32)
+}
+
+// ValidScope reports whether the scope exists.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Has reports whether the granted scopes cover the wanted one.
+func Has(granted []string, want string) bool {
+	for _, scope := range granted {
+		if scope == want {
+			return true
+		}
+		for _, s := range implied[scope] {
+			if s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authenticate looks the token up and returns it with its user, and notes
+// when and from where it was used.
+func Authenticate(raw, ip string) (*models.PersonalAccessToken, *models.User, error) {
+	var token models.PersonalAccessToken
+	err := db.Where("token_hash = ?", utils.HashToken(raw)).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+		return nil, nil, ErrExpiredToken
+	}
+
+	var user models.User
+	if err := db.First(&user, "id = ?", token.UserID).Error; err != nil {
+		return nil, nil, err
+	}
+	if user.DisabledAt != nil {
+		return nil, nil, ErrUserDisabled
+	}
+
+	// a bot can make a lot of requests; a minute is precise enough
+	now := time.Now()
+	db.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", token.ID, now.Add(-time.Minute)).
+		Updates(map[string]interface{}{"last_used_at": now, "last_used_ip": ip})
+
+	return &token, &user, nil
+}
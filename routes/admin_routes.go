@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/dipankarupd/text-editor/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func AdminRoutes(route *gin.Engine) {
+	admin := route.Group("/admin", controllers.RequireAdmin())
+	admin.GET("/users", controllers.GetUsers())
+	admin.GET("/users/:id", controllers.GetAdminUser())
+	admin.POST("/users/:id/disable", controllers.DisableUser())
+	admin.POST("/users/:id/enable", controllers.EnableUser())
+	admin.POST("/users/:id/logout", controllers.ForceLogoutUser())
+	admin.PATCH("/users/:id/role", controllers.UpdateUserRole())
+	admin.POST("/users/:id/documents/transfer", controllers.TransferUserDocuments())
+	admin.POST("/documents/:id/transfer", controllers.TransferDocument())
+	admin.GET("/audit", controllers.GetAuditEvents())
+}
@@ -9,12 +9,34 @@ import (
 func DocumentRoutes(route *gin.Engine) {
 	route.POST("/documents", controllers.CreateDocument())
 	route.GET("/documents/me", controllers.GetUserDocuments())
+	route.GET("/documents/recent", controllers.GetRecentDocuments())
 	route.GET("/documents/:id", controllers.GetDocumentByID())
+	route.GET("/documents/:id/activity", controllers.GetDocumentActivity())
+	route.GET("/documents/:id/contributors", controllers.GetDocumentContributors())
 	route.PATCH("/documents/:id", controllers.UpdateDocumentTitle()) 
+	route.PATCH("/documents/:id/content", controllers.UpdateDocumentContent())
+	route.PATCH("/documents/:id/metadata", controllers.UpdateDocumentMetadata())
+	route.POST("/documents/:id/sync", controllers.SyncDocument())
+	route.POST("/documents/:id/template", controllers.SaveAsTemplate())
+	route.POST("/documents/:id/copy", controllers.CopyDocument())
+	route.POST("/documents/:id/access-requests", controllers.CreateAccessRequest())
+	route.GET("/documents/:id/access-requests", controllers.GetAccessRequests())
+	route.POST("/documents/:id/access-requests/:requestId/approve", controllers.ApproveAccessRequest())
+	route.POST("/documents/:id/access-requests/:requestId/deny", controllers.DenyAccessRequest())
+	route.GET("/documents/:id/permissions", controllers.GetDocumentPermissions())
+	route.DELETE("/documents/:id/permissions/:userId", controllers.RevokeDocumentPermission())
+	route.POST("/documents/:id/webhooks", controllers.CreateDocumentWebhook())
+	route.GET("/documents/:id/webhooks", controllers.GetDocumentWebhooks())
+}
+
+func TemplateRoutes(route *gin.Engine) {
+	route.GET("/templates", controllers.GetTemplates())
+	route.DELETE("/templates/:id", controllers.DeleteTemplate())
 }
 
 // websocket route
 
 func WebSocketRoutes(route *gin.Engine) {
 	route.GET("/ws/:docId", ws.WebSocketHandler)
+	route.GET("/ws/me", ws.UserChannelHandler)
 }
\ No newline at end of file
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/dipankarupd/text-editor/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func NotificationRoutes(route *gin.Engine) {
+	route.GET("/notifications", controllers.GetNotifications())
+	route.POST("/notifications/read", controllers.MarkNotificationsRead())
+	route.POST("/notifications/:id/read", controllers.MarkNotificationRead())
+	route.GET("/notifications/preferences", controllers.GetNotificationPreferences())
+	route.PUT("/notifications/preferences", controllers.UpdateNotificationPreferences())
+}
@@ -1,21 +1,53 @@
 package routes
 
 import (
+	"time"
+
 	"github.com/dipankarupd/text-editor/controllers"
+	"github.com/dipankarupd/text-editor/middlewares"
 	"github.com/gin-gonic/gin"
 )
 
 func UserRoutes(route *gin.Engine) {
-	route.POST("/users/register", controllers.RegisterUser())
-	route.GET("/users", controllers.GetUsers())
-	route.GET("/users/:id", controllers.GetUser())
-	route.POST("/users/login", controllers.Login())
-	route.POST("/users/login/google", controllers.LoginWithGoogle())
-	route.GET("/refresh", controllers.RefreshHandler())
+	route.POST("/users/register", middlewares.RateLimit("register", 10, time.Hour, middlewares.ByIP), controllers.RegisterUser())
+	route.POST("/users/login", middlewares.RateLimit("login", 10, time.Minute, middlewares.ByIP), controllers.Login())
+	route.POST("/users/login/2fa", middlewares.RateLimit("login_2fa", 10, time.Minute, middlewares.ByIP), controllers.CompleteTwoFactorLogin())
+	route.POST("/users/login/google", middlewares.RateLimit("login_google", 20, time.Minute, middlewares.ByIP), controllers.LoginWithGoogle())
+	route.POST("/users/login/google/link", middlewares.RateLimit("login", 10, time.Minute, middlewares.ByIP), controllers.LinkGoogleAccount())
+	route.GET("/refresh", middlewares.RateLimit("refresh", 60, time.Minute, middlewares.ByIP), controllers.RefreshHandler())
+	route.POST("/users/email/verify", middlewares.RateLimit("email_token", 20, time.Hour, middlewares.ByIP), controllers.VerifyEmail())
+	route.POST("/users/password/forgot", middlewares.RateLimit("password_forgot", 5, time.Hour, middlewares.ByIP), controllers.ForgotPassword())
+	route.POST("/users/password/reset", middlewares.RateLimit("email_token", 20, time.Hour, middlewares.ByIP), controllers.ResetPassword())
+	route.POST("/users/email/confirm", middlewares.RateLimit("email_token", 20, time.Hour, middlewares.ByIP), controllers.ConfirmEmailChange())
+
+	route.GET("/auth/providers", controllers.GetOAuthProviders())
+	route.GET("/auth/:provider/authorize", controllers.AuthorizeOAuth())
+	route.GET("/auth/:provider/callback", middlewares.RateLimit("oauth_callback", 30, time.Minute, middlewares.ByIP), controllers.OAuthCallback())
 }
 
 
 func UserSecureRoutes(route *gin.Engine) {
 	route.GET("/users/me", controllers.GetLoggedInUser())
+	route.GET("/users/:id", controllers.GetUser())
+	route.PATCH("/users/me", controllers.UpdateProfile())
+	route.DELETE("/users/me", controllers.DeleteAccount())
+	route.POST("/users/me/password", controllers.ChangePassword())
+	route.POST("/users/me/email", controllers.RequestEmailChange())
+	route.GET("/users/me/2fa", controllers.GetTwoFactorStatus())
+	route.POST("/users/me/2fa/totp", controllers.EnrollTOTP())
+	route.POST("/users/me/2fa/totp/verify", controllers.VerifyTOTP())
+	route.DELETE("/users/me/2fa/totp", controllers.DisableTOTP())
+	route.POST("/users/me/2fa/recovery-codes", controllers.RegenerateRecoveryCodes())
 	route.POST("/users/logout", controllers.Logout())
+	route.POST("/users/logout/all", controllers.LogoutAll())
+	route.POST("/users/email/verify/resend", middlewares.RateLimit("verify_resend", 3, time.Hour, middlewares.ByUser), controllers.ResendVerificationEmail())
+	route.GET("/users/me/identities", controllers.GetUserIdentities())
+	route.POST("/users/me/identities/:provider/authorize", controllers.LinkOAuthIdentity())
+	route.DELETE("/users/me/identities/:id", controllers.UnlinkUserIdentity())
+	route.GET("/users/me/tokens", controllers.GetAccessTokens())
+	route.POST("/users/me/tokens", controllers.CreateAccessToken())
+	route.DELETE("/users/me/tokens/:id", controllers.RevokeAccessToken())
+	route.GET("/users/me/sessions", controllers.GetSessions())
+	route.DELETE("/users/me/sessions", controllers.RevokeOtherSessions())
+	route.DELETE("/users/me/sessions/:id", controllers.RevokeSession())
 }
\ No newline at end of file
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/dipankarupd/text-editor/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func WebhookRoutes(route *gin.Engine) {
+	route.GET("/webhooks/:id", controllers.GetWebhook())
+	route.PATCH("/webhooks/:id", controllers.UpdateWebhook())
+	route.DELETE("/webhooks/:id", controllers.DeleteWebhook())
+	route.POST("/webhooks/:id/secret", controllers.RotateWebhookSecret())
+	route.POST("/webhooks/:id/ping", controllers.PingWebhook())
+	route.GET("/webhooks/:id/deliveries", controllers.GetWebhookDeliveries())
+	route.POST("/webhooks/:id/deliveries/:deliveryId/redeliver", controllers.RedeliverWebhook())
+}
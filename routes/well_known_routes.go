@@ -0,0 +1,10 @@
+package routes
+
+import (
+	"github.com/dipankarupd/text-editor/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func WellKnownRoutes(route *gin.Engine) {
+	route.GET("/.well-known/jwks.json", controllers.GetJWKS())
+}
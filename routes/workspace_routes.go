@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/dipankarupd/text-editor/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func WorkspaceRoutes(route *gin.Engine) {
+	route.POST("/workspaces", controllers.CreateWorkspace())
+	route.GET("/workspaces", controllers.GetWorkspaces())
+	route.GET("/workspaces/:id", controllers.GetWorkspace())
+	route.POST("/workspaces/:id/members", controllers.AddWorkspaceMember())
+	route.PATCH("/workspaces/:id/members/:userId", controllers.UpdateWorkspaceMember())
+	route.DELETE("/workspaces/:id/members/:userId", controllers.RemoveWorkspaceMember())
+	route.GET("/workspaces/:id/documents", controllers.GetWorkspaceDocuments())
+	route.GET("/workspaces/:id/tags", controllers.GetWorkspaceTags())
+	route.GET("/workspaces/:id/properties", controllers.GetWorkspaceProperties())
+	route.POST("/workspaces/:id/properties", controllers.CreateWorkspaceProperty())
+	route.PATCH("/workspaces/:id/properties/:propertyId", controllers.UpdateWorkspaceProperty())
+	route.DELETE("/workspaces/:id/properties/:propertyId", controllers.DeleteWorkspaceProperty())
+	route.POST("/workspaces/:id/webhooks", controllers.CreateWorkspaceWebhook())
+	route.GET("/workspaces/:id/webhooks", controllers.GetWorkspaceWebhooks())
+}
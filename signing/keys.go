@@ -0,0 +1,349 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/oauth"
+	"github.com/golang-jwt/jwt"
+	"gorm.io/gorm"
+)
+
+const (
+	// new keys show up in the JWKS this long before they sign anything, so
+	// services caching our keys have them by the time tokens use them
+	prepublish = time.Hour
+	// a retired key stays valid as long as the longest lived token it signed
+	verifyFor = 168 * time.Hour
+
+	defaultRotation = 30 * 24 * time.Hour
+	rotationLockID  = 3604
+)
+
+var (
+	ErrNoSigningKey = errors.New("no active signing key")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+var db *gorm.DB
+
+func InitDb(database *gorm.DB) {
+	db = database
+}
+
+// private keys are stored encrypted with these, utils.Encrypt and
+// utils.Decrypt (utils imports this package, so main passes them in)
+var encrypt, decrypt func(string) (string, error)
+
+func InitCipher(encryptFn, decryptFn func(string) (string, error)) {
+	encrypt, decrypt = encryptFn, decryptFn
+}
+
+// Key is a loaded signing key.
+type Key struct {
+	Kid         string
+	Alg         string
+	ActivatesAt time.Time
+	PrivateKey  crypto.Signer
+}
+
+// Method is the jwt signing method for the key's algorithm.
+func (k *Key) Method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Alg)
+}
+
+func (k *Key) Public() crypto.PublicKey {
+	return k.PrivateKey.Public()
+}
+
+var ring = struct {
+	sync.RWMutex
+	keys     []*Key // oldest first
+	loadedAt time.Time
+}{}
+
+// Start makes sure there is a key to sign with, then keeps rotating keys in
+// the background. Every instance runs it; the rotation itself is serialised
+// through a postgres advisory lock.
+func Start() {
+	if err := rotate(); err != nil {
+		log.Fatalf("❌ Failed to set up JWT signing keys: %v", err)
+	}
+	if err := load(); err != nil {
+		log.Fatalf("❌ Failed to load JWT signing keys: %v", err)
+	}
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := rotate(); err != nil {
+				log.Printf("JWT key rotation failed: %v", err)
+			}
+			if err := load(); err != nil {
+				log.Printf("Failed to reload JWT signing keys: %v", err)
+			}
+		}
+	}()
+}
+
+// Current returns the key new tokens are signed with: the most recently
+// activated one.
+func Current() (*Key, error) {
+	ring.RLock()
+	defer ring.RUnlock()
+
+	now := time.Now()
+	for i := len(ring.keys) - 1; i >= 0; i-- {
+		if !ring.keys[i].ActivatesAt.After(now) {
+			return ring.keys[i], nil
+		}
+	}
+	return nil, ErrNoSigningKey
+}
+
+// Lookup finds a key by kid for verification. A kid we don't know yet may
+// have just been created by another instance, so that triggers a reload.
+func Lookup(kid string) (*Key, error) {
+	if key := find(kid); key != nil {
+		return key, nil
+	}
+
+	ring.RLock()
+	stale := time.Since(ring.loadedAt) > 10*time.Second
+	ring.RUnlock()
+	if stale {
+		if err := load(); err != nil {
+			return nil, err
+		}
+		if key := find(kid); key != nil {
+			return key, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+func find(kid string) *Key {
+	ring.RLock()
+	defer ring.RUnlock()
+	for _, key := range ring.keys {
+		if key.Kid == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// JWKS lists the public half of every key still in use, including the next
+// key before it activates.
+func JWKS() oauth.JWKSet {
+	ring.RLock()
+	defer ring.RUnlock()
+
+	set := oauth.JWKSet{Keys: []oauth.JWK{}}
+	for i := len(ring.keys) - 1; i >= 0; i-- {
+		key := ring.keys[i]
+		jwk, err := oauth.NewJWK(key.Kid, key.Alg, key.Public())
+		if err != nil {
+			log.Printf("Can't publish signing key %s: %v", key.Kid, err)
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func load() error {
+	var rows []models.SigningKey
+	if err := db.Order("activates_at").Find(&rows).Error; err != nil {
+		return err
+	}
+
+	keys := make([]*Key, 0, len(rows))
+	for _, row := range rows {
+		private, err := decodePrivateKey(row.PrivateKey)
+		if err != nil {
+			log.Printf("Skipping signing key %s: %v", row.Kid, err)
+			continue
+		}
+		keys = append(keys, &Key{Kid: row.Kid, Alg: row.Alg, ActivatesAt: row.ActivatesAt, PrivateKey: private})
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].ActivatesAt.Before(keys[j].ActivatesAt) })
+
+	ring.Lock()
+	ring.keys = keys
+	ring.loadedAt = time.Now()
+	ring.Unlock()
+	return nil
+}
+
+// rotate creates the next key once the newest one is due for replacement,
+// or right away when there is none or JWT_SIGNING_ALG changed, and deletes
+// keys whose tokens can no longer be valid.
+func rotate() error {
+	alg, err := configuredAlg()
+	if err != nil {
+		return err
+	}
+	rotation := rotationInterval()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", rotationLockID).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+
+		var latest models.SigningKey
+		err := tx.Order("activates_at DESC").First(&latest).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			// first start, nothing to prepublish for
+			if err := createKey(tx, alg, now); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case latest.Alg != alg || !now.Before(latest.ActivatesAt.Add(rotation-prepublish)):
+			// a pending key is never due, so this only fires once per rotation
+			if err := createKey(tx, alg, now.Add(prepublish)); err != nil {
+				return err
+			}
+		}
+		if err := encryptPlaintextKeys(tx); err != nil {
+			return err
+		}
+
+		// anything older than the newest key that's been signing for
+		// verifyFor has no valid tokens left
+		return tx.Exec(`DELETE FROM signing_keys WHERE activates_at < (
+			SELECT max(activates_at) FROM signing_keys WHERE activates_at <= ?
+		)`, now.Add(-verifyFor)).Error
+	})
+}
+
+func createKey(tx *gorm.DB, alg string, activatesAt time.Time) error {
+	private, err := generateKey(alg)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+	kid := make([]byte, 12)
+	if _, err := rand.Read(kid); err != nil {
+		return err
+	}
+
+	encrypted, err := encrypt(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+	if err != nil {
+		return err
+	}
+
+	row := models.SigningKey{
+		Kid:         base64.RawURLEncoding.EncodeToString(kid),
+		Alg:         alg,
+		PrivateKey:  encrypted,
+		ActivatesAt: activatesAt,
+	}
+	if err := tx.Create(&row).Error; err != nil {
+		return err
+	}
+	log.Printf("Created %s signing key %s, active from %s", alg, row.Kid, activatesAt.Format(time.RFC3339))
+	return nil
+}
+
+func generateKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case "RS256":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "EdDSA":
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+}
+
+// encryptPlaintextKeys encrypts keys stored before private keys were
+// encrypted.
+func encryptPlaintextKeys(tx *gorm.DB) error {
+	var rows []models.SigningKey
+	if err := tx.Where("private_key LIKE ?", pemPrefix+"%").Find(&rows).Error; err != nil {
+		return err
+	}
+	for _, row := range rows {
+		encrypted, err := encrypt(row.PrivateKey)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&row).Update("private_key", encrypted).Error; err != nil {
+			return err
+		}
+		log.Printf("Encrypted signing key %s", row.Kid)
+	}
+	return nil
+}
+
+const pemPrefix = "-----BEGIN"
+
+func decodePrivateKey(stored string) (crypto.Signer, error) {
+	encoded := stored
+	// plaintext PEM until encryptPlaintextKeys gets to it
+	if !strings.HasPrefix(stored, pemPrefix) {
+		var err error
+		if encoded, err = decrypt(stored); err != nil {
+			return nil, err
+		}
+	}
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+	return signer, nil
+}
+
+// configuredAlg reads JWT_SIGNING_ALG, RS256 by default.
+func configuredAlg() (string, error) {
+	switch alg := os.Getenv("JWT_SIGNING_ALG"); alg {
+	case "":
+		return "RS256", nil
+	case "RS256", "EdDSA":
+		return alg, nil
+	default:
+		return "", fmt.Errorf("unsupported JWT_SIGNING_ALG %q, use RS256 or EdDSA", alg)
+	}
+}
+
+// rotationInterval reads JWT_KEY_ROTATION as a Go duration, 720h by default.
+func rotationInterval() time.Duration {
+	raw := os.Getenv("JWT_KEY_ROTATION")
+	if raw == "" {
+		return defaultRotation
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 2*prepublish {
+		log.Printf("⚠️ Invalid JWT_KEY_ROTATION %q, using %s", raw, defaultRotation)
+		return defaultRotation
+	}
+	return d
+}
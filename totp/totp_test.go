@@ -0,0 +1,100 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// the ASCII secret "12345678901234567890" from RFC 4226 and RFC 6238
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 4226 Appendix D. The HOTP values are the first steps of TOTP.
+func TestHOTPVectors(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	key, err := encoding.DecodeString(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for counter, w := range want {
+		if got := code(key, int64(counter)); got != w {
+			t.Errorf("counter %d: got %s, want %s", counter, got, w)
+		}
+	}
+}
+
+// RFC 6238 Appendix B, SHA-1. The RFC prints 8 digits; with 6 they are the
+// last 6 of those.
+func TestTOTPVectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		at := time.Unix(tt.unix, 0)
+		got, err := Code(rfcSecret, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d: got %s, want %s", tt.unix, got, tt.want)
+		}
+		if step, ok := Validate(rfcSecret, tt.want, at); !ok || step != Step(at) {
+			t.Errorf("Validate at %d: got step %d, %v", tt.unix, step, ok)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	tests := []struct {
+		name   string
+		offset time.Duration
+		ok     bool
+	}{
+		{"previous step", -Period * time.Second, true},
+		{"next step", Period * time.Second, true},
+		{"two steps back", -2 * Period * time.Second, false},
+		{"two steps ahead", 2 * Period * time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, at.Add(tt.offset))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := Validate(rfcSecret, code, at); ok != tt.ok {
+				t.Errorf("got %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	at := time.Unix(59, 0)
+	tests := []struct {
+		input string
+		ok    bool
+	}{
+		{"287082", true},
+		{" 287 082 ", true},
+		{"28708", false},
+		{"2870820", false},
+		{"000000", false},
+	}
+	for _, tt := range tests {
+		if _, ok := Validate(rfcSecret, tt.input, at); ok != tt.ok {
+			t.Errorf("Validate(%q) = %v, want %v", tt.input, ok, tt.ok)
+		}
+	}
+	// secrets are accepted in lower case, as some apps show them that way
+	if _, ok := Validate("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "287082", at); !ok {
+		t.Error("lower case secret rejected")
+	}
+}
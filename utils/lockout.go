@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	db "github.com/dipankarupd/text-editor/db"
+	"github.com/redis/go-redis/v9"
+)
+
+// Failed sign ins are counted per account, whether or not the account exists
+// (so a lock doesn't reveal that). From the fifth failure on, each one locks
+// the account for twice as long as the last, up to an hour.
+const (
+	lockoutFreeAttempts = 5
+	lockoutBase         = time.Minute
+	lockoutMax          = time.Hour
+	lockoutMemory       = 24 * time.Hour
+)
+
+func lockoutKey(account string) string {
+	return HashToken(strings.ToLower(strings.TrimSpace(account)))
+}
+
+// LoginLockedFor returns how long the account is still locked for, or zero.
+func LoginLockedFor(ctx context.Context, account string) (time.Duration, error) {
+	ttl, err := db.RedisClient.PTTL(ctx, "login_lock:"+lockoutKey(account)).Result()
+	if errors.Is(err, redis.Nil) || ttl < 0 {
+		return 0, nil
+	}
+	return ttl, err
+}
+
+// RecordLoginFailure counts a failed attempt and returns the lock it caused,
+// if any.
+func RecordLoginFailure(ctx context.Context, account string) (time.Duration, error) {
+	key := lockoutKey(account)
+	pipe := db.RedisClient.TxPipeline()
+	failures := pipe.Incr(ctx, "login_failures:"+key)
+	pipe.Expire(ctx, "login_failures:"+key, lockoutMemory)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	over := failures.Val() - lockoutFreeAttempts
+	if over < 0 {
+		return 0, nil
+	}
+	lock := lockoutMax
+	if over < 6 {
+		lock = lockoutBase << over
+		if lock > lockoutMax {
+			lock = lockoutMax
+		}
+	}
+	return lock, db.RedisClient.Set(ctx, "login_lock:"+key, 1, lock).Err()
+}
+
+// ResetLoginFailures forgets the failures after a successful sign in.
+func ResetLoginFailures(ctx context.Context, account string) error {
+	key := lockoutKey(account)
+	return db.RedisClient.Del(ctx, "login_failures:"+key, "login_lock:"+key).Err()
+}
+
+// At most this many password reset mails go to one address per hour, so a
+// victim can't be flooded with them from many addresses.
+const resetMailsPerHour = 3
+
+// AllowPasswordResetMail counts a reset mail for the address and reports
+// whether it is within the limit. Addresses without an account are counted
+// the same way.
+func AllowPasswordResetMail(ctx context.Context, email string) (bool, error) {
+	key := "reset_mails:" + lockoutKey(email)
+	pipe := db.RedisClient.TxPipeline()
+	sent := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return sent.Val() <= resetMailsPerHour, nil
+}
@@ -2,75 +2,180 @@ package utils
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"time"
 
 	db "github.com/dipankarupd/text-editor/db"
+	"github.com/dipankarupd/text-editor/signing"
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 )
 
-var SECRET_KEY = os.Getenv("SECRET_KEY")
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 168 * time.Hour
+)
+
+// ErrRefreshTokenReused means a token that was already rotated out of its
+// session was presented again, so someone else may hold a copy of it.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// Token types, so a refresh token can't be used as an access token or the
+// other way round.
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
+)
 
 type SignedDetails struct {
-	Name   string
-	Email  string
-	UserId uuid.UUID
+	Name      string
+	Email     string
+	UserId    uuid.UUID
+	SessionId uuid.UUID
+	TokenType string
+	// iat in milliseconds, so a revocation can tell apart tokens issued in
+	// the same second as it
+	IssuedAtMs int64
 	jwt.StandardClaims
 }
 
+// IsType checks the token type. Tokens from before it was set have none, and
+// most have no iat either, so they are told apart by the time they have left:
+// an access token never has more than AccessTokenTTL.
+func (c *SignedDetails) IsType(tokenType string) bool {
+	if c.TokenType != "" {
+		return c.TokenType == tokenType
+	}
+	long := c.ExpiresAt-time.Now().Unix() > int64(AccessTokenTTL/time.Second)
+	return long == (tokenType == RefreshToken)
+}
+
+// issuedAtMs is when the token was issued, in milliseconds.
+func (c *SignedDetails) issuedAtMs() int64 {
+	if c.IssuedAtMs != 0 {
+		return c.IssuedAtMs
+	}
+	return c.IssuedAt * 1000
+}
+
 func GenerateAccessAndRefreshToken(
 	userId uuid.UUID,
 	name string,
 	email string,
+	sessionId uuid.UUID,
 ) (signedAccessToken string, signedRefreshToken string, err error) {
 
+	now := time.Now()
 	claims := &SignedDetails{
-		Name:   name,
-		Email:  email,
-		UserId: userId,
+		Name:       name,
+		Email:      email,
+		UserId:     userId,
+		SessionId:  sessionId,
+		TokenType:  AccessToken,
+		IssuedAtMs: now.UnixMilli(),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Minute * time.Duration(15)).Unix(),
+			Id:        uuid.NewString(),
+			Issuer:    os.Getenv("JWT_ISSUER"),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL).Unix(),
 		},
 	}
 
 	refreshClaims := &SignedDetails{
-		Name:   name,
-		Email:  email,
-		UserId: userId,
+		Name:       name,
+		Email:      email,
+		UserId:     userId,
+		SessionId:  sessionId,
+		TokenType:  RefreshToken,
+		IssuedAtMs: now.UnixMilli(),
 		StandardClaims: jwt.StandardClaims{
-
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(168)).Unix(),
+			// unique per token, so two refreshes in the same second differ
+			Id:        uuid.NewString(),
+			Issuer:    os.Getenv("JWT_ISSUER"),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(RefreshTokenTTL).Unix(),
 		},
 	}
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	key, err := signing.Current()
+	if err != nil {
+		return "", "", err
+	}
+
+	token, err := sign(key, claims)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := sign(key, refreshClaims)
+	if err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
+}
 
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(SECRET_KEY))
+func sign(key *signing.Key, claims *SignedDetails) (string, error) {
+	token := jwt.NewWithClaims(key.Method(), claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.PrivateKey)
+}
 
+// verificationKey picks the key a token says it was signed with. Tokens from
+// before asymmetric signing have no kid and are HS256 with SECRET_KEY; they
+// are accepted only while SECRET_KEY is still set.
+func verificationKey(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		secret := os.Getenv("SECRET_KEY")
+		if secret == "" || t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("token has no kid")
+		}
+		return []byte(secret), nil
+	}
+
+	key, err := signing.Lookup(kid)
 	if err != nil {
-		log.Panic(err)
-		return
+		return nil, err
 	}
-	return token, refreshToken, err
+	// never let the token pick a different algorithm than the key's
+	if t.Method.Alg() != key.Alg {
+		return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+	}
+	return key.Public(), nil
+}
+
+func UpdateTokens(ctx context.Context, refreshToken string, sessionId uuid.UUID) error {
+
+	// every session keeps its own refresh token, so signing in on one
+	// device doesn't log the others out. Only the hash is stored.
+	return db.RedisClient.Set(ctx, refreshTokenKey(sessionId), HashToken(refreshToken), RefreshTokenTTL).Err()
 }
 
-func UpdateTokens(ctx context.Context, refreshToken string, userId uuid.UUID) error {
+// RevokeRefreshTokens drops the refresh tokens of the given sessions.
+func RevokeRefreshTokens(ctx context.Context, sessionIds ...uuid.UUID) error {
+	if len(sessionIds) == 0 {
+		return nil
+	}
+	keys := make([]string, len(sessionIds))
+	for i, id := range sessionIds {
+		keys[i] = refreshTokenKey(id)
+	}
+	return db.RedisClient.Del(ctx, keys...).Err()
+}
 
-	// from db.RedisClient, get the redis client,
-	// update the refresh token for the userid.
-	return db.RedisClient.Set(ctx, "refresh_token:"+userId.String(), refreshToken, 168*time.Hour).Err()
+func refreshTokenKey(sessionId uuid.UUID) string {
+	return "refresh_token:" + sessionId.String()
 }
 
 func ValidateToken(tokenString string) (*SignedDetails, string) {
 	parsedToken, err := jwt.ParseWithClaims(
 		tokenString,
 		&SignedDetails{},
-		func(t *jwt.Token) (interface{}, error) {
-			return []byte(SECRET_KEY), nil
-		},
+		verificationKey,
 	)
 
 	if err != nil {
@@ -93,41 +198,65 @@ func ValidateToken(tokenString string) (*SignedDetails, string) {
 	return claims, ""
 }
 
-func RefreshTokens(refreshToken string, ctx context.Context) (newAccessToken string, newRefreshToken string, err error) {
-	// First, parse the token to extract the claims (without full validation yet)
+// RefreshTokens swaps a refresh token for a new pair in the same session and
+// returns the session id so the caller can record its use. A session is a
+// token family: only its latest token is accepted, and an older one returns
+// ErrRefreshTokenReused after the whole session has been cut off.
+// profile returns the user's current name and email for the new tokens, or
+// an error when the user may no longer sign in.
+func RefreshTokens(
+	refreshToken string,
+	ctx context.Context,
+	profile func(userId uuid.UUID) (name string, email string, err error),
+) (newAccessToken string, newRefreshToken string, sessionId uuid.UUID, err error) {
 	claims, msg := ValidateToken(refreshToken)
-	if msg != "" {
-		return "", "", fmt.Errorf("invalid refresh token")
+	if msg != "" || !claims.IsType(RefreshToken) {
+		return "", "", uuid.Nil, fmt.Errorf("invalid refresh token")
 	}
-	fmt.Println(claims)
-	// Fetch token from Redis using userId
-	fmt.Println("refresh_token:" + claims.UserId.String())
-	storedToken, err := db.RedisClient.Get(ctx, "refresh_token:"+claims.UserId.String()).Result()
-	if err != nil {
-		return "", "", fmt.Errorf("refresh token not found in Redis or Redis error: %v", err)
+	// tokens issued before sessions existed have no session to refresh
+	if claims.SessionId == uuid.Nil {
+		return "", "", uuid.Nil, fmt.Errorf("refresh token has no session")
 	}
-
-	// Compare stored token with the provided one
-	if storedToken != refreshToken {
-		return "", "", fmt.Errorf("refresh token mismatch")
+	if err := CheckTokenRevoked(ctx, claims); err != nil {
+		return "", "", uuid.Nil, err
+	}
+	name, email, err := profile(claims.UserId)
+	if err != nil {
+		return "", "", uuid.Nil, err
 	}
 
-	// Now, we can be confident in the token's legitimacy and proceed
 	newAccessToken, newRefreshToken, err = GenerateAccessAndRefreshToken(
 		claims.UserId,
-		claims.Name,
-		claims.Email,
+		name,
+		email,
+		claims.SessionId,
 	)
 
 	if err != nil {
-		return "", "", fmt.Errorf("failed to generate new tokens: %v", err)
+		return "", "", uuid.Nil, fmt.Errorf("failed to generate new tokens: %v", err)
 	}
 
-	// Store new refresh token in Redis (replacing the old one)
-	err = db.RedisClient.Set(ctx, "refresh_token:"+claims.UserId.String(), newRefreshToken, 168*time.Hour).Err()
+	// swap in the new token in one step so two refreshes racing with the
+	// same token can't both win; XX leaves revoked sessions alone
+	storedHash, err := db.RedisClient.SetArgs(ctx, refreshTokenKey(claims.SessionId), HashToken(newRefreshToken), redis.SetArgs{
+		Mode: "XX",
+		TTL:  RefreshTokenTTL,
+		Get:  true,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", "", uuid.Nil, fmt.Errorf("refresh token not found in Redis")
+	}
 	if err != nil {
-		return "", "", fmt.Errorf("failed to store in redis")
+		return "", "", uuid.Nil, fmt.Errorf("failed to store in redis: %v", err)
+	}
+
+	// the signature is ours, so a mismatch is an older token of this family
+	if subtle.ConstantTimeCompare([]byte(storedHash), []byte(HashToken(refreshToken))) != 1 {
+		if err := RevokeRefreshTokens(ctx, claims.SessionId); err != nil {
+			log.Printf("failed to revoke refresh tokens of session %s: %v", claims.SessionId, err)
+		}
+		return "", "", claims.SessionId, ErrRefreshTokenReused
 	}
 
-	return newAccessToken, newRefreshToken, nil
+	return newAccessToken, newRefreshToken, claims.SessionId, nil
 }
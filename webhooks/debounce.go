@@ -0,0 +1,71 @@
+package webhooks
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// document.updated goes out once edits stop for quietPeriod, or after
+	// maxWait while someone keeps typing
+	quietPeriod = 10 * time.Second
+	maxWait     = time.Minute
+)
+
+type pendingUpdate struct {
+	timer *time.Timer
+	first time.Time
+}
+
+var updates = struct {
+	sync.Mutex
+	docs map[uuid.UUID]*pendingUpdate
+}{docs: make(map[uuid.UUID]*pendingUpdate)}
+
+// DocumentChanged notes an edit; a document.updated with the content as it
+// is then is dispatched when the edits settle. The timers live in memory, so
+// with several instances each one may send its own snapshot.
+func DocumentChanged(docID uuid.UUID) {
+	updates.Lock()
+	defer updates.Unlock()
+
+	p, ok := updates.docs[docID]
+	if !ok {
+		p = &pendingUpdate{first: time.Now()}
+		p.timer = time.AfterFunc(quietPeriod, func() { flushUpdate(docID, p) })
+		updates.docs[docID] = p
+		return
+	}
+	wait := quietPeriod
+	if left := maxWait - time.Since(p.first); left < wait {
+		wait = left
+	}
+	p.timer.Reset(wait)
+}
+
+func flushUpdate(docID uuid.UUID, p *pendingUpdate) {
+	updates.Lock()
+	// a Reset racing with the timer can fire it twice
+	if updates.docs[docID] != p {
+		updates.Unlock()
+		return
+	}
+	delete(updates.docs, docID)
+	updates.Unlock()
+
+	var doc models.Document
+	if err := db.First(&doc, "id = ?", docID).Error; err != nil {
+		log.Printf("Failed to load document %s for webhooks: %v", docID, err)
+		return
+	}
+	data := map[string]interface{}{
+		"content":        doc.Content,
+		"last_edited_by": doc.LastEditedBy,
+		"last_edited_at": doc.LastEditedAt,
+	}
+	Dispatch(DocumentUpdated, &doc, data)
+}
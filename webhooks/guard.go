@@ -0,0 +1,95 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned for webhook URLs that point into our own
+// network: loopback, private, link-local and the like.
+var ErrPrivateAddress = errors.New("webhook URLs must point at a public address")
+
+// carrier-grade NAT and "this network", which net.IP has no helpers for
+var blockedNets = []*net.IPNet{
+	mustCIDR("100.64.0.0/10"),
+	mustCIDR("0.0.0.0/8"),
+}
+
+func mustCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// allowPrivate lets webhooks reach local receivers, for development and
+// tests only.
+func allowPrivate() bool {
+	return os.Getenv("WEBHOOK_ALLOW_PRIVATE_URLS") == "true"
+}
+
+func publicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckURL refuses URLs whose host is or resolves to a non-public address.
+// The dialer checks again on every delivery, as DNS can change.
+func CheckURL(u *url.URL) error {
+	if allowPrivate() {
+		return nil
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !publicIP(ip) {
+			return ErrPrivateAddress
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return errors.New("webhook host can't be resolved")
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// dialer checks the address actually connected to, after resolution, so a
+// host can't pass CheckURL and later resolve somewhere internal.
+var dialer = &net.Dialer{
+	Timeout: 10 * time.Second,
+	Control: func(network, address string, _ syscall.RawConn) error {
+		if allowPrivate() {
+			return nil
+		}
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if !publicIP(net.ParseIP(host)) {
+			return ErrPrivateAddress
+		}
+		return nil
+	},
+}
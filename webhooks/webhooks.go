@@ -0,0 +1,153 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Events a webhook can subscribe to.
+const (
+	DocumentCreated         = "document.created"
+	DocumentUpdated         = "document.updated"
+	DocumentTitleChanged    = "document.title_changed"
+	DocumentMetadataChanged = "document.metadata_changed"
+	DocumentShared          = "document.shared"
+	DocumentDeleted         = "document.deleted"
+
+	// sent by the ping endpoint only, whatever the webhook subscribed to
+	Ping = "ping"
+)
+
+var Events = []string{DocumentCreated, DocumentUpdated, DocumentTitleChanged, DocumentMetadataChanged, DocumentShared, DocumentDeleted}
+
+func ValidEvent(event string) bool {
+	for _, known := range Events {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+var db *gorm.DB
+
+func InitDb(database *gorm.DB) {
+	db = database
+}
+
+func GenerateSecret() string {
+	return "whsec_" + utils.RandomToken(24)
+}
+
+// Sign is the X-Webhook-Signature of a body sent at timestamp (unix
+// seconds): an HMAC-SHA256 of "<timestamp>.<body>" keyed with the secret.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type payload struct {
+	ID        uuid.UUID              `json:"id"`
+	Event     string                 `json:"event"`
+	CreatedAt time.Time              `json:"created_at"`
+	Document  documentSummary        `json:"document"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+type documentSummary struct {
+	ID          uuid.UUID  `json:"id"`
+	Title       string     `json:"title"`
+	AuthorID    uuid.UUID  `json:"author_id"`
+	WorkspaceID *uuid.UUID `json:"workspace_id"`
+	Revision    int64      `json:"revision"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Dispatch queues the event for every active webhook watching the document
+// or its workspace. The worker started by Start sends them. Like the audit
+// log, failing to queue is only logged.
+// Webhooks only fire while whoever set them up could still do so: the
+// document's author, or an admin of the workspace.
+func Dispatch(event string, doc *models.Document, data map[string]interface{}) {
+	query := db.Where("active AND events @> ?", `["`+event+`"]`)
+	if doc.WorkspaceID != nil {
+		query = query.Where(`(document_id = ? AND created_by = ?) OR (workspace_id = ? AND EXISTS (
+			SELECT 1 FROM workspace_members m
+			WHERE m.workspace_id = webhooks.workspace_id AND m.user_id = webhooks.created_by AND m.role = ?))`,
+			doc.ID, doc.AuthorID, *doc.WorkspaceID, models.WorkspaceRoleAdmin)
+	} else {
+		query = query.Where("document_id = ? AND created_by = ?", doc.ID, doc.AuthorID)
+	}
+	var hooks []models.Webhook
+	if err := query.Find(&hooks).Error; err != nil {
+		log.Printf("Failed to look up webhooks for %s on %s: %v", event, doc.ID, err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	// the same id goes to every webhook and stays the same on retries, so
+	// receivers can drop duplicates
+	body, err := json.Marshal(payload{
+		ID:        uuid.New(),
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Document: documentSummary{
+			ID:          doc.ID,
+			Title:       doc.Title,
+			AuthorID:    doc.AuthorID,
+			WorkspaceID: doc.WorkspaceID,
+			Revision:    doc.Revision,
+			UpdatedAt:   doc.UpdatedAt,
+		},
+		Data: data,
+	})
+	if err != nil {
+		log.Printf("Failed to encode %s webhook payload: %v", event, err)
+		return
+	}
+	for _, hook := range hooks {
+		if _, err := Enqueue(hook.ID, event, body); err != nil {
+			log.Printf("Failed to queue %s for webhook %s: %v", event, hook.ID, err)
+		}
+	}
+}
+
+// DropForTransfer deletes the webhooks the previous authors set up on
+// documents given to newAuthor; they'd otherwise keep getting its content.
+func DropForTransfer(tx *gorm.DB, docIDs []uuid.UUID, newAuthor uuid.UUID) error {
+	if len(docIDs) == 0 {
+		return nil
+	}
+	return tx.Where("document_id IN ? AND created_by IS DISTINCT FROM ?", docIDs, newAuthor).Delete(&models.Webhook{}).Error
+}
+
+// Enqueue adds a delivery to the log, to be sent right away. Redelivering
+// enqueues the old payload again, so it keeps its event id.
+func Enqueue(webhookID uuid.UUID, event string, body []byte) (*models.WebhookDelivery, error) {
+	delivery := models.WebhookDelivery{
+		ID:            uuid.New(),
+		WebhookID:     webhookID,
+		Event:         event,
+		Payload:       body,
+		Status:        models.DeliveryPending,
+		NextAttemptAt: time.Now(),
+	}
+	if err := db.Create(&delivery).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
@@ -0,0 +1,165 @@
+package webhooks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dipankarupd/text-editor/models"
+	"gorm.io/gorm"
+)
+
+const (
+	maxAttempts  = 8
+	firstBackoff = 30 * time.Second
+	maxBackoff   = time.Hour
+	// how long a claimed delivery is left alone before another worker may
+	// try it, in case this one dies mid-send
+	claimLease = 2 * time.Minute
+	batchSize  = 20
+)
+
+var client = &http.Client{
+	Timeout: 10 * time.Second,
+	// no proxy, so the dialer sees the receiver's own address
+	Transport: &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        50,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	// a redirect would send the signed payload somewhere else
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// Start sends due deliveries every few seconds. Every instance can run it;
+// rows are claimed with SKIP LOCKED so each delivery goes out once.
+func Start() {
+	go func() {
+		for range time.Tick(5 * time.Second) {
+			if err := deliverDue(); err != nil {
+				log.Printf("Webhook delivery failed: %v", err)
+			}
+		}
+	}()
+}
+
+func deliverDue() error {
+	var due []models.WebhookDelivery
+	err := db.Raw(`
+		UPDATE webhook_deliveries SET next_attempt_at = ?
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = ? AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+	`, time.Now().Add(claimLease), models.DeliveryPending, batchSize).Scan(&due).Error
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	for _, d := range due {
+		go func(d models.WebhookDelivery) {
+			attempt(d)
+			done <- struct{}{}
+		}(d)
+	}
+	for range due {
+		<-done
+	}
+	return nil
+}
+
+// attempt sends the delivery once and records how it went, scheduling the
+// next try with exponential backoff until maxAttempts.
+func attempt(d models.WebhookDelivery) {
+	var hook models.Webhook
+	err := db.First(&hook, "id = ?", d.WebhookID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load webhook %s: %v", d.WebhookID, err)
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if !hook.Active {
+		updates["status"] = models.DeliveryFailed
+		updates["last_error"] = "webhook is disabled"
+		if err := db.Model(&models.WebhookDelivery{}).Where("id = ?", d.ID).Updates(updates).Error; err != nil {
+			log.Printf("Failed to record webhook delivery %s: %v", d.ID, err)
+		}
+		return
+	}
+
+	updates["attempts"] = d.Attempts + 1
+	status, sendErr := send(hook, d)
+	if status != 0 {
+		updates["last_status_code"] = status
+	}
+	switch {
+	case sendErr == nil:
+		updates["status"] = models.DeliverySucceeded
+		updates["delivered_at"] = time.Now()
+		updates["last_error"] = nil
+	case d.Attempts+1 >= maxAttempts:
+		updates["status"] = models.DeliveryFailed
+		updates["last_error"] = sendErr.Error()
+	default:
+		updates["next_attempt_at"] = time.Now().Add(Backoff(d.Attempts + 1))
+		updates["last_error"] = sendErr.Error()
+	}
+	if err := db.Model(&models.WebhookDelivery{}).Where("id = ?", d.ID).Updates(updates).Error; err != nil {
+		log.Printf("Failed to record webhook delivery %s: %v", d.ID, err)
+	}
+}
+
+// Backoff is the wait after the nth failed attempt: 30s, 1m, 2m, ... up to
+// an hour.
+func Backoff(attempts int) time.Duration {
+	wait := firstBackoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+// send posts the payload and returns the response status; anything but a
+// 2xx is an error.
+func send(hook models.Webhook, d models.WebhookDelivery) (int, error) {
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Collaborative-Editor-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", d.ID.String())
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", Sign(hook.Secret, timestamp, d.Payload))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("receiver answered %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
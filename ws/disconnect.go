@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dipankarupd/text-editor/access"
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+// every open connection, joined to a room or not, so revoked sessions can be
+// cut off
+var connections = struct {
+	sync.Mutex
+	all map[*Connection]bool
+}{all: make(map[*Connection]bool)}
+
+func trackConnection(c *Connection) {
+	connections.Lock()
+	connections.all[c] = true
+	connections.Unlock()
+}
+
+func untrackConnection(c *Connection) {
+	connections.Lock()
+	delete(connections.all, c)
+	connections.Unlock()
+}
+
+// DisconnectUser closes every connection of the user.
+func DisconnectUser(userID uuid.UUID) {
+	disconnect(func(c *Connection) bool {
+		return c.userID != nil && *c.userID == userID
+	})
+}
+
+// DisconnectSessions closes the connections opened with tokens of the
+// sessions.
+func DisconnectSessions(sessionIDs ...uuid.UUID) {
+	ids := make(map[uuid.UUID]bool, len(sessionIDs))
+	for _, id := range sessionIDs {
+		ids[id] = true
+	}
+	disconnect(func(c *Connection) bool {
+		return c.sessionID != uuid.Nil && ids[c.sessionID]
+	})
+}
+
+// RecheckAccess looks the user's access up again in every document they have
+// open and closes the connections that lost some of it, after a permission
+// was revoked or they left a workspace. They can reconnect with whatever
+// access is left.
+func RecheckAccess(userID uuid.UUID) {
+	type joined struct {
+		c     *Connection
+		room  string
+		level access.Level
+	}
+	manager.Lock()
+	var open []joined
+	for room, clients := range manager.rooms {
+		for c := range clients {
+			if c.userID != nil && *c.userID == userID {
+				open = append(open, joined{c, room, c.level})
+			}
+		}
+	}
+	manager.Unlock()
+
+	levels := make(map[string]access.Level)
+	var closing []*Connection
+	for _, j := range open {
+		level, ok := levels[j.room]
+		if !ok {
+			level = currentLevel(userID, j.room)
+			levels[j.room] = level
+		}
+		if level < j.level {
+			closing = append(closing, j.c)
+		}
+	}
+	closeConnections(closing, "access revoked")
+}
+
+// currentLevel is the user's access to the document, None when it's gone or
+// can't be checked.
+func currentLevel(userID uuid.UUID, room string) access.Level {
+	var doc models.Document
+	if err := db.Omit("content").First(&doc, "id = ?", room).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Failed to recheck access to document %s: %v", room, err)
+		}
+		return access.None
+	}
+	level, err := access.DocumentLevel(userID, &doc)
+	if err != nil {
+		log.Printf("Failed to recheck access to document %s: %v", room, err)
+		return access.None
+	}
+	return level
+}
+
+func disconnect(match func(c *Connection) bool) {
+	connections.Lock()
+	var closing []*Connection
+	for c := range connections.all {
+		if match(c) {
+			closing = append(closing, c)
+		}
+	}
+	connections.Unlock()
+	closeConnections(closing, "session revoked")
+}
+
+func closeConnections(closing []*Connection, reason string) {
+	// closing the socket ends the read loop, which cleans up the room
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	for _, c := range closing {
+		c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		c.ws.Close()
+	}
+}
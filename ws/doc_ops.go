@@ -0,0 +1,253 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/ot"
+	"github.com/dipankarupd/text-editor/webhooks"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sources recorded in document_ops
+const (
+	SourceSocket = "socket"
+	SourceSave   = "save"
+	SourceSync   = "sync"
+	SourceUndo   = "undo"
+	SourceRedo   = "redo"
+	SourceAPI    = "api"
+)
+
+// LatestRevision applies a change to whatever the document currently holds.
+const LatestRevision int64 = -1
+
+// statuses reported per change in ApplyChanges
+const (
+	ChangeApplied  = "applied"
+	ChangeConflict = "conflict"
+	ChangeSkipped  = "skipped"
+)
+
+var (
+	ErrRevisionAhead      = errors.New("base revision is ahead of the document")
+	ErrInvalidDocument    = errors.New("stored document content is not a valid delta")
+	ErrPreconditionFailed = errors.New("document changed since it was read")
+)
+
+type ChangeResult struct {
+	Index    int             `json:"index"`
+	Status   string          `json:"status"`
+	Revision int64           `json:"revision,omitempty"`
+	Delta    json.RawMessage `json:"delta,omitempty"`
+	Error    string          `json:"error,omitempty"`
+
+	inverse ot.Delta
+}
+
+// ApplyChanges rebases a sequence of changes, each made on top of the previous
+// one starting from baseRevision, onto the current document and persists them
+// one revision per change. A change that doesn't fit the document is reported
+// as a conflict and the changes after it, which depend on it, are skipped.
+func ApplyChanges(
+	docID uuid.UUID,
+	userID *uuid.UUID,
+	baseRevision int64,
+	changes []ot.Delta,
+	source string,
+) ([]ChangeResult, *models.Document, error) {
+	return applyChanges(docID, userID, baseRevision, source, func(*models.Document, ot.Delta) ([]ot.Delta, error) {
+		return changes, nil
+	})
+}
+
+// ReplaceContent swaps the document's content for a new one, saved as the
+// change from what it holds now. match is checked with the document locked,
+// so nothing can slip in between; when it returns false nothing is saved and
+// the error is ErrPreconditionFailed.
+func ReplaceContent(
+	docID uuid.UUID,
+	userID *uuid.UUID,
+	content ot.Delta,
+	match func(doc *models.Document) bool,
+) ([]ChangeResult, *models.Document, error) {
+	return applyChanges(docID, userID, LatestRevision, SourceAPI, func(doc *models.Document, current ot.Delta) ([]ot.Delta, error) {
+		if !match(doc) {
+			return nil, ErrPreconditionFailed
+		}
+		return []ot.Delta{current.Diff(content)}, nil
+	})
+}
+
+// applyChanges does the work of ApplyChanges; build returns the changes once
+// the document is locked and its content parsed.
+func applyChanges(
+	docID uuid.UUID,
+	userID *uuid.UUID,
+	baseRevision int64,
+	source string,
+	build func(doc *models.Document, content ot.Delta) ([]ot.Delta, error),
+) ([]ChangeResult, *models.Document, error) {
+
+	var results []ChangeResult
+	var doc models.Document
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&doc, "id = ?", docID).Error; err != nil {
+			return err
+		}
+		if baseRevision == LatestRevision {
+			baseRevision = doc.Revision
+		}
+		if baseRevision > doc.Revision {
+			return ErrRevisionAhead
+		}
+
+		content, err := ot.Parse(doc.Content)
+		if err != nil || !content.IsDocument() {
+			return ErrInvalidDocument
+		}
+		changes, err := build(&doc, content)
+		if err != nil {
+			return err
+		}
+		results = make([]ChangeResult, len(changes))
+
+		// ops the client hasn't seen yet, rebased as we go so they stay
+		// concurrent with the next change in the batch
+		var history []models.DocumentOp
+		if err := tx.Where("document_id = ? AND revision > ?", docID, baseRevision).
+			Order("revision").Find(&history).Error; err != nil {
+			return err
+		}
+		concurrent := make([]ot.Delta, 0, len(history))
+		for _, h := range history {
+			d, err := ot.Parse(h.Delta)
+			if err != nil {
+				return err
+			}
+			concurrent = append(concurrent, d)
+		}
+
+		failed, applied := false, false
+		for i, change := range changes {
+			results[i].Index = i
+			if failed {
+				results[i].Status = ChangeSkipped
+				results[i].Error = "depends on a conflicting change"
+				continue
+			}
+
+			change = ot.Rebase(change, concurrent)
+
+			if change.BaseLength() > content.Length() {
+				results[i].Status = ChangeConflict
+				results[i].Error = "change does not fit the document"
+				failed = true
+				continue
+			}
+			// e.g. deleting text someone else already removed
+			if len(change.Ops) == 0 {
+				results[i].Status = ChangeApplied
+				continue
+			}
+
+			inverse := change.Invert(content)
+			content = content.Compose(change)
+			doc.Revision++
+
+			op := models.DocumentOp{
+				ID:         uuid.New(),
+				DocumentID: doc.ID,
+				UserID:     userID,
+				Revision:   doc.Revision,
+				Delta:      change.JSON(),
+				Inverse:    inverse.JSON(),
+				Source:     source,
+			}
+			if err := tx.Create(&op).Error; err != nil {
+				return err
+			}
+
+			results[i].Status = ChangeApplied
+			results[i].Revision = doc.Revision
+			results[i].Delta = op.Delta
+			results[i].inverse = inverse
+			applied = true
+		}
+		if !applied {
+			return nil
+		}
+
+		doc.Content = content.Raw()
+		doc.UpdatedAt = time.Now()
+		updates := map[string]interface{}{
+			"content":    doc.Content,
+			"revision":   doc.Revision,
+			"updated_at": doc.UpdatedAt,
+		}
+		if userID != nil {
+			doc.LastEditedBy = userID
+			doc.LastEditedAt = &doc.UpdatedAt
+			updates["last_edited_by"] = *userID
+			updates["last_edited_at"] = doc.UpdatedAt
+		}
+		if err := tx.Model(&doc).Updates(updates).Error; err != nil {
+			return err
+		}
+		if userID != nil {
+			return recordEdit(tx, *userID, doc.ID, doc.UpdatedAt)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if userID != nil {
+		recordUndo(docID.String(), *userID, source, results)
+	}
+	for _, r := range results {
+		if r.Status == ChangeApplied && r.Revision > 0 {
+			webhooks.DocumentChanged(docID)
+			break
+		}
+	}
+	return results, &doc, nil
+}
+
+// BroadcastChanges sends applied changes to everyone in the document's room
+// except the sender, which may be nil.
+func BroadcastChanges(sender *Connection, docID string, results []ChangeResult) {
+	for _, r := range results {
+		if r.Status != ChangeApplied || r.Delta == nil {
+			continue
+		}
+		broadcastToRoom(docID, sender, map[string]interface{}{
+			"event":    "changes",
+			"data":     r.Delta,
+			"revision": r.Revision,
+		})
+	}
+}
+
+func broadcastToRoom(roomID string, sender *Connection, payload interface{}) {
+	manager.Lock()
+	defer manager.Unlock()
+
+	for client := range manager.rooms[roomID] {
+		if client == sender {
+			continue
+		}
+		if err := client.send(payload); err != nil {
+			log.Println("Write error:", err)
+			client.ws.Close()
+			delete(manager.rooms[roomID], client)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package ws
+
+import (
+	"log"
+	"sync"
+
+	"github.com/dipankarupd/text-editor/ot"
+	"github.com/google/uuid"
+)
+
+const maxUndoDepth = 100
+
+// undoEntry is the inverse of one of a user's changes, expressed against the
+// document at the revision that change produced.
+type undoEntry struct {
+	revision int64
+	inverse  ot.Delta
+}
+
+type undoStacks struct {
+	undo []undoEntry
+	redo []undoEntry
+}
+
+// UndoManager keeps an undo and a redo stack per user per document, so undo
+// only ever reverts the caller's own changes.
+type UndoManager struct {
+	sync.Mutex
+	stacks map[string]map[uuid.UUID]*undoStacks
+}
+
+var undoManager = UndoManager{stacks: make(map[string]map[uuid.UUID]*undoStacks)}
+
+func (m *UndoManager) get(docID string, userID uuid.UUID) *undoStacks {
+	if m.stacks[docID] == nil {
+		m.stacks[docID] = make(map[uuid.UUID]*undoStacks)
+	}
+	s := m.stacks[docID][userID]
+	if s == nil {
+		s = &undoStacks{}
+		m.stacks[docID][userID] = s
+	}
+	return s
+}
+
+func push(stack []undoEntry, e undoEntry) []undoEntry {
+	stack = append(stack, e)
+	if len(stack) > maxUndoDepth {
+		stack = stack[len(stack)-maxUndoDepth:]
+	}
+	return stack
+}
+
+// recordUndo files applied changes on the right stack: normal edits go on the
+// undo stack and invalidate redo, an undo can be redone and a redo undone.
+func recordUndo(docID string, userID uuid.UUID, source string, results []ChangeResult) {
+	// only live edits can be undone; stacks go away when the user's last
+	// socket leaves, so edits over HTTP would pile up for good
+	if source != SourceSocket && source != SourceUndo && source != SourceRedo {
+		return
+	}
+	undoManager.Lock()
+	defer undoManager.Unlock()
+
+	s := undoManager.get(docID, userID)
+	for _, r := range results {
+		if r.Status != ChangeApplied || r.Delta == nil {
+			continue
+		}
+		e := undoEntry{revision: r.Revision, inverse: r.inverse}
+		switch source {
+		case SourceUndo:
+			s.redo = push(s.redo, e)
+		case SourceRedo:
+			s.undo = push(s.undo, e)
+		default:
+			s.undo = push(s.undo, e)
+			s.redo = nil
+		}
+	}
+}
+
+func popUndo(docID string, userID uuid.UUID, redo bool) (undoEntry, bool) {
+	undoManager.Lock()
+	defer undoManager.Unlock()
+
+	s := undoManager.get(docID, userID)
+	stack := &s.undo
+	if redo {
+		stack = &s.redo
+	}
+	if len(*stack) == 0 {
+		return undoEntry{}, false
+	}
+	e := (*stack)[len(*stack)-1]
+	*stack = (*stack)[:len(*stack)-1]
+	return e, true
+}
+
+// restoreUndo puts back an entry popUndo took when applying it failed, so the
+// user can try again.
+func restoreUndo(docID string, userID uuid.UUID, redo bool, e undoEntry) {
+	undoManager.Lock()
+	defer undoManager.Unlock()
+
+	s := undoManager.get(docID, userID)
+	if redo {
+		s.redo = push(s.redo, e)
+	} else {
+		s.undo = push(s.undo, e)
+	}
+}
+
+// forgetUndo drops the stacks of a user who left the document.
+func forgetUndo(docID string, userID uuid.UUID) {
+	undoManager.Lock()
+	defer undoManager.Unlock()
+
+	if users, ok := undoManager.stacks[docID]; ok {
+		delete(users, userID)
+		if len(users) == 0 {
+			delete(undoManager.stacks, docID)
+		}
+	}
+}
+
+// applyUndo reverts (or re-applies) the caller's last change. The stored
+// inverse is rebased over everything that happened since, including edits by
+// other users, and broadcast to the whole room as a normal change.
+func applyUndo(client *Connection, redo bool) {
+	if !canEdit(client) {
+		return
+	}
+	docID, err := uuid.Parse(client.roomID)
+	if err != nil {
+		client.sendError("invalid document ID")
+		return
+	}
+
+	source := SourceUndo
+	if redo {
+		source = SourceRedo
+	}
+
+	entry, ok := popUndo(client.roomID, *client.userID, redo)
+	if !ok {
+		client.sendError("nothing to " + source)
+		return
+	}
+	results, _, err := ApplyChanges(docID, client.userID, entry.revision, []ot.Delta{entry.inverse}, source)
+	if err != nil {
+		log.Printf("Failed to %s on document %s: %v", source, client.roomID, err)
+		restoreUndo(client.roomID, *client.userID, redo, entry)
+		client.sendError("failed to " + source)
+		return
+	}
+	if results[0].Status != ChangeApplied {
+		restoreUndo(client.roomID, *client.userID, redo, entry)
+		client.sendError(results[0].Error)
+		return
+	}
+
+	BroadcastChanges(nil, client.roomID, results)
+}
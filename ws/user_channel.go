@@ -0,0 +1,76 @@
+package ws
+
+import (
+	"log"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// userChannels holds the connections listening for things addressed to a
+// user rather than a document, like notifications.
+var userChannels = struct {
+	sync.Mutex
+	users map[uuid.UUID]map[*Connection]bool
+}{users: make(map[uuid.UUID]map[*Connection]bool)}
+
+// UserChannelHandler opens the signed in user's own channel. Nothing is read
+// from it; it only carries what PushToUser sends.
+func UserChannelHandler(c *gin.Context) {
+	claims, ok := authenticate(c)
+	if !ok {
+		return
+	}
+	userID := claims.UserId
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Println("WebSocket upgrade error:", err)
+		return
+	}
+
+	client := &Connection{ws: conn, userID: &userID, sessionID: claims.SessionId}
+	trackConnection(client)
+	userChannels.Lock()
+	if userChannels.users[userID] == nil {
+		userChannels.users[userID] = make(map[*Connection]bool)
+	}
+	userChannels.users[userID][client] = true
+	userChannels.Unlock()
+
+	defer func() {
+		userChannels.Lock()
+		delete(userChannels.users[userID], client)
+		if len(userChannels.users[userID]) == 0 {
+			delete(userChannels.users, userID)
+		}
+		userChannels.Unlock()
+		untrackConnection(client)
+		conn.Close()
+	}()
+
+	// reading is how a closed connection is noticed
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+// PushToUser sends the payload to every open channel of the user.
+func PushToUser(userID uuid.UUID, payload interface{}) {
+	userChannels.Lock()
+	clients := make([]*Connection, 0, len(userChannels.users[userID]))
+	for client := range userChannels.users[userID] {
+		clients = append(clients, client)
+	}
+	userChannels.Unlock()
+
+	for _, client := range clients {
+		if err := client.send(payload); err != nil {
+			log.Println("Write error:", err)
+			client.ws.Close()
+		}
+	}
+}
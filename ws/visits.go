@@ -0,0 +1,35 @@
+package ws
+
+import (
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RecordOpen notes that the user opened the document, for their recent
+// documents. A failure only costs an entry in that list, so it's logged.
+func RecordOpen(userID, docID uuid.UUID) {
+	err := db.Exec(`
+		INSERT INTO document_visits (user_id, document_id, last_opened_at, last_activity_at)
+		VALUES (?, ?, now(), now())
+		ON CONFLICT (user_id, document_id) DO UPDATE SET
+			last_opened_at = EXCLUDED.last_opened_at,
+			last_activity_at = EXCLUDED.last_activity_at
+	`, userID, docID).Error
+	if err != nil {
+		log.Printf("Failed to record visit of document %s: %v", docID, err)
+	}
+}
+
+// recordEdit notes the edit on both the document and the user's visit.
+func recordEdit(tx *gorm.DB, userID, docID uuid.UUID, at time.Time) error {
+	return tx.Exec(`
+		INSERT INTO document_visits (user_id, document_id, last_edited_at, last_activity_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, document_id) DO UPDATE SET
+			last_edited_at = EXCLUDED.last_edited_at,
+			last_activity_at = EXCLUDED.last_activity_at
+	`, userID, docID, at, at).Error
+}
@@ -6,7 +6,10 @@ import (
 	"net/http"
 	"sync"
 
+	"github.com/dipankarupd/text-editor/access"
 	"github.com/dipankarupd/text-editor/models"
+	"github.com/dipankarupd/text-editor/ot"
+	"github.com/dipankarupd/text-editor/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -19,14 +22,33 @@ func InitDb(database *gorm.DB) {
 }
 
 type Message struct {
-	Event string          `json:"event"` // "join", "typing", "save"
-	Room  string          `json:"room"`  // documentId
-	Data  json.RawMessage `json:"data"`  // delta for "typing"
+	Event    string          `json:"event"`              // "join", "typing", "save", "undo", "redo"
+	Room     string          `json:"room"`               // documentId
+	Data     json.RawMessage `json:"data"`               // delta for "typing"
+	Revision *int64          `json:"revision,omitempty"` // revision a "typing" delta was made against
 }
 
 type Connection struct {
-	ws     *websocket.Conn
-	roomID string
+	ws        *websocket.Conn
+	roomID    string
+	userID    *uuid.UUID
+	sessionID uuid.UUID
+	level     access.Level // access to the joined document
+
+	writeMu sync.Mutex
+}
+
+// send serialises writes; gorilla connections allow only one writer at a time.
+func (c *Connection) send(payload interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.WriteJSON(payload)
+}
+
+func (c *Connection) sendError(msg string) {
+	if err := c.send(map[string]interface{}{"event": "error", "error": msg}); err != nil {
+		log.Println("Write error:", err)
+	}
 }
 
 type RoomManager struct {
@@ -40,16 +62,54 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
+// authenticate checks the access token of a websocket handshake and writes
+// the error response when it isn't good.
+func authenticate(c *gin.Context) (*utils.SignedDetails, bool) {
+	// browsers can't set headers on a websocket handshake, so the access
+	// token may also come as a query parameter
+	token := c.Query("token")
+	if token == "" {
+		token = c.Request.Header.Get("token")
+	}
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "no token provided"})
+		return nil, false
+	}
+	claims, msg := utils.ValidateActiveToken(c.Request.Context(), token)
+	if msg != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+		return nil, false
+	}
+	disabled, err := userDisabled(claims.UserId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not check account"})
+		return nil, false
+	}
+	if disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This account has been disabled"})
+		return nil, false
+	}
+	return claims, true
+}
+
 func WebSocketHandler(c *gin.Context) {
+	claims, ok := authenticate(c)
+	if !ok {
+		return
+	}
+	userID := &claims.UserId
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Println("WebSocket upgrade error:", err)
 		return
 	}
 	
-	client := &Connection{ws: conn}
+	client := &Connection{ws: conn, userID: userID, sessionID: claims.SessionId}
+	trackConnection(client)
 
 	defer func() {
+		untrackConnection(client)
 		removeClientFromRoom(client)
 		conn.Close()
 	}()
@@ -63,15 +123,19 @@ func WebSocketHandler(c *gin.Context) {
 
 		switch msg.Event {
 		case "join":
-			client.roomID = msg.Room
-			addClientToRoom(client)
-			log.Printf("Client joined room: %s\n", msg.Room)
+			joinRoom(client, msg.Room)
 
 		case "typing":
-			broadcastToOthers(client, msg)
+			applyTyping(client, msg)
 
 		case "save":
-			saveMessage(msg.Room, msg.Data)
+			saveMessage(client, msg.Data)
+
+		case "undo":
+			applyUndo(client, false)
+
+		case "redo":
+			applyUndo(client, true)
 
 		default:
 			log.Printf("Unknown event: %s\n", msg.Event)
@@ -80,10 +144,78 @@ func WebSocketHandler(c *gin.Context) {
 }
 
 
-func addClientToRoom(c *Connection) {
+// userDisabled reports whether an admin disabled the account.
+func userDisabled(userID uuid.UUID) (bool, error) {
+	var count int64
+	err := db.Model(&models.User{}).Where("id = ? AND disabled_at IS NOT NULL", userID).Count(&count).Error
+	return count > 0, err
+}
+
+// joinRoom moves the client into a document's room if they can read it.
+func joinRoom(client *Connection, room string) {
+	docID, err := uuid.Parse(room)
+	if err != nil {
+		client.sendError("invalid document ID")
+		return
+	}
+	// disabling closes open sockets, this catches a join racing with it
+	if disabled, err := userDisabled(*client.userID); err != nil || disabled {
+		client.sendError("this account has been disabled")
+		return
+	}
+
+	var doc models.Document
+	if err := db.First(&doc, "id = ?", docID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			client.sendError("document not found")
+		} else {
+			log.Printf("Database error when joining document %s: %v", room, err)
+			client.sendError("failed to join")
+		}
+		return
+	}
+	level, err := access.DocumentLevel(*client.userID, &doc)
+	if err != nil {
+		log.Printf("Database error when joining document %s: %v", room, err)
+		client.sendError("failed to join")
+		return
+	}
+	if level < access.Read {
+		client.sendError("you don't have permission to access this document")
+		return
+	}
+
+	// re-joining the same room only refreshes the level; leaving it would
+	// drop the user's undo history
+	if client.roomID != room {
+		removeClientFromRoom(client)
+	}
+	addClientToRoom(client, room, level)
+	RecordOpen(*client.userID, doc.ID)
+	log.Printf("Client joined room: %s\n", room)
+}
+
+// canEdit checks the client joined a room they may write to.
+func canEdit(client *Connection) bool {
+	if client.roomID == "" {
+		client.sendError("join a room first")
+		return false
+	}
+	if client.level < access.Write {
+		client.sendError("you only have read access to this document")
+		return false
+	}
+	return true
+}
+
+// addClientToRoom sets the room and level under the lock, so RecheckAccess
+// can read them from other goroutines.
+func addClientToRoom(c *Connection, room string, level access.Level) {
 	manager.Lock()
 	defer manager.Unlock()
 
+	c.roomID = room
+	c.level = level
 	if manager.rooms[c.roomID] == nil {
 		manager.rooms[c.roomID] = make(map[*Connection]bool)
 	}
@@ -100,36 +232,75 @@ func removeClientFromRoom(c *Connection) {
 			delete(manager.rooms, c.roomID)
 		}
 	}
+
+	// undo history lives as long as the user has the document open somewhere
+	if c.userID == nil {
+		return
+	}
+	for other := range manager.rooms[c.roomID] {
+		if other.userID != nil && *other.userID == *c.userID {
+			return
+		}
+	}
+	forgetUndo(c.roomID, *c.userID)
 }
 
-func broadcastToOthers(sender *Connection, msg Message) {
-	manager.Lock()
-	defer manager.Unlock()
+// applyTyping records a live edit and relays the rebased delta to the rest
+// of the room. Clients that track revisions send the one they edited against;
+// otherwise the edit is applied to the latest revision.
+func applyTyping(client *Connection, msg Message) {
+	if !canEdit(client) {
+		return
+	}
+	docID, err := uuid.Parse(client.roomID)
+	if err != nil {
+		client.sendError("invalid document ID")
+		return
+	}
+	change, err := ot.Parse(msg.Data)
+	if err != nil {
+		client.sendError("invalid delta")
+		return
+	}
 
-	for client := range manager.rooms[sender.roomID] {
-		if client == sender {
-			continue
-		}
-		err := client.ws.WriteJSON(map[string]interface{}{
-			"event": "changes",
-			"data":  msg.Data, // Quill Delta JSON
-		})
-		if err != nil {
-			log.Println("Write error:", err)
-			client.ws.Close()
-			delete(manager.rooms[sender.roomID], client)
-		}
+	base := LatestRevision
+	if msg.Revision != nil {
+		base = *msg.Revision
+	}
+	results, doc, err := ApplyChanges(docID, client.userID, base, []ot.Delta{change}, SourceSocket)
+	if err != nil {
+		log.Printf("Failed to apply change to document %s: %v", client.roomID, err)
+		client.sendError("failed to apply change")
+		return
+	}
+	if results[0].Status != ChangeApplied {
+		client.sendError(results[0].Error)
+		return
+	}
+
+	BroadcastChanges(client, client.roomID, results)
+	if err := client.send(map[string]interface{}{"event": "ack", "revision": doc.Revision}); err != nil {
+		log.Println("Write error:", err)
 	}
 }
 
-func saveMessage(docId string, message json.RawMessage) {
-	log.Printf("%v", message)
+func saveMessage(client *Connection, message json.RawMessage) {
+	if !canEdit(client) {
+		return
+	}
+	docId := client.roomID
 	documentID, err := uuid.Parse(docId)
 	if err != nil {
 		log.Printf("Invalid document ID: %s, error: %v", docId, err)
 		return
 	}
 
+	saved, err := ot.Parse(message)
+	if err != nil || !saved.IsDocument() {
+		log.Printf("Invalid content for document %s", docId)
+		return
+	}
+
 	// First, check if the document exists
 	var doc models.Document
 	if err := db.Where("id = ?", documentID).First(&doc).Error; err != nil {
@@ -141,11 +312,22 @@ func saveMessage(docId string, message json.RawMessage) {
 		return
 	}
 
-	// Update the document content
-	if err := db.Model(&doc).Update("content", message).Error; err != nil {
+	current, err := ot.Parse(doc.Content)
+	if err != nil {
+		log.Printf("Stored content of document %s is not a valid delta: %v", docId, err)
+		return
+	}
+
+	// record the save as the change from the stored content so the
+	// revision history stays complete
+	change := current.Diff(saved)
+	if len(change.Ops) == 0 {
+		return
+	}
+	if _, _, err := ApplyChanges(documentID, client.userID, doc.Revision, []ot.Delta{change}, SourceSave); err != nil {
 		log.Printf("Failed to save document %s: %v", docId, err)
 		return
 	}
 
 	log.Printf("Document %s saved successfully", docId)
-}
\ No newline at end of file
+}